@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/byteness/keyring"
+)
+
+const dialTimeout = 2 * time.Second
+
+// Client is a keyring.Keyring forwarding all operations to a running agent
+type Client struct {
+	SocketPath string
+}
+
+func (c *Client) call(m message) (*reply, error) {
+	conn, err := net.DialTimeout("unix", c.SocketPath, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(m); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("Error reading agent reply: %w", err)
+	}
+	var r reply
+	if err := json.Unmarshal(line, &r); err != nil {
+		return nil, fmt.Errorf("Invalid agent reply: %w", err)
+	}
+	if r.NotFound {
+		return &r, keyring.ErrKeyNotFound
+	}
+	if r.Error != "" {
+		return &r, errors.New(r.Error)
+	}
+	return &r, nil
+}
+
+// Ping checks that the agent is alive
+func (c *Client) Ping() error {
+	_, err := c.call(message{Op: opPing})
+	return err
+}
+
+// Stop asks the agent to shut down
+func (c *Client) Stop() error {
+	_, err := c.call(message{Op: opStop})
+	return err
+}
+
+func (c *Client) Get(key string) (keyring.Item, error) {
+	r, err := c.call(message{Op: opGet, Key: key})
+	if err != nil {
+		return keyring.Item{}, err
+	}
+	if r.Item == nil {
+		return keyring.Item{}, keyring.ErrKeyNotFound
+	}
+	return *r.Item, nil
+}
+
+func (c *Client) GetMetadata(key string) (keyring.Metadata, error) {
+	item, err := c.Get(key)
+	if err != nil {
+		return keyring.Metadata{}, err
+	}
+	return keyring.Metadata{Item: &item}, nil
+}
+
+func (c *Client) Set(item keyring.Item) error {
+	_, err := c.call(message{Op: opSet, Item: &item})
+	return err
+}
+
+func (c *Client) Remove(key string) error {
+	_, err := c.call(message{Op: opRemove, Key: key})
+	return err
+}
+
+func (c *Client) Keys() ([]string, error) {
+	r, err := c.call(message{Op: opKeys})
+	if err != nil {
+		return nil, err
+	}
+	return r.Keys, nil
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const startTimeout = 10 * time.Second
+
+// StartDaemon runs the aurl executable with args in the background, detached from the
+// terminal, and waits until the agent answers on the socket
+func StartDaemon(args []string, socketPath string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	client := &Client{SocketPath: socketPath}
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case waitErr := <-exited:
+			return pid, fmt.Errorf("Agent exited during startup (%v), try running it in the foreground with: aurl agent serve --socket %s", waitErr, socketPath)
+		default:
+		}
+		if err = client.Ping(); err == nil {
+			return pid, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return pid, fmt.Errorf("Agent did not come up on %s: %w", socketPath, err)
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// checkSocketDir refuses a directory other users could open, or replace the socket in
+func checkSocketDir(dir string, info os.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("Socket directory %s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("Socket directory %s is accessible by other users (mode %s), use a directory with mode 0700", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// listen creates the socket without any permission for group and others, rather than restricting it
+// after the fact, which would let other users connect in between
+func listen(path string) (net.Listener, error) {
+	umask := syscall.Umask(0077)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
+
+// checkPeer refuses connections from processes of other users
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("Connection is not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var uid int
+	var uidErr error
+	if err := raw.Control(func(fd uintptr) { uid, uidErr = peerUID(int(fd)) }); err != nil {
+		return err
+	}
+	if uidErr != nil {
+		return fmt.Errorf("Error reading the peer credentials: %w", uidErr)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("Connection from user %d refused", uid)
+	}
+	return nil
+}
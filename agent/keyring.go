@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/byteness/keyring"
+)
+
+// CachingKeyring keeps the items of the backend keyring in memory once they have been read,
+// so the backend (and its unlock prompt) is only hit once per item
+type CachingKeyring struct {
+	Backend keyring.Keyring
+
+	mu    sync.Mutex
+	items map[string]keyring.Item
+	keys  []string
+}
+
+func NewCachingKeyring(backend keyring.Keyring) *CachingKeyring {
+	return &CachingKeyring{
+		Backend: backend,
+		items:   map[string]keyring.Item{},
+	}
+}
+
+func (k *CachingKeyring) Get(key string) (keyring.Item, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if item, ok := k.items[key]; ok {
+		return item, nil
+	}
+	item, err := k.Backend.Get(key)
+	if err != nil {
+		return item, err
+	}
+	k.items[key] = item
+	return item, nil
+}
+
+func (k *CachingKeyring) GetMetadata(key string) (keyring.Metadata, error) {
+	item, err := k.Get(key)
+	if err != nil {
+		return keyring.Metadata{}, err
+	}
+	return keyring.Metadata{Item: &item}, nil
+}
+
+func (k *CachingKeyring) Set(item keyring.Item) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.Backend.Set(item); err != nil {
+		return err
+	}
+	k.items[item.Key] = item
+	k.keys = nil
+	return nil
+}
+
+func (k *CachingKeyring) Remove(key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.items, key)
+	k.keys = nil
+	return k.Backend.Remove(key)
+}
+
+func (k *CachingKeyring) Keys() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		keys, err := k.Backend.Keys()
+		if err != nil {
+			return nil, err
+		}
+		k.keys = keys
+	}
+	return append([]string{}, k.keys...), nil
+}
+
+// CachedItems returns a snapshot of the items held in memory
+func (k *CachingKeyring) CachedItems() []keyring.Item {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	items := make([]keyring.Item, 0, len(k.items))
+	for _, item := range k.items {
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,12 @@
+package agent
+
+import "golang.org/x/sys/unix"
+
+// peerUID returns the user of the process connected to the socket
+func peerUID(fd int) (int, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !linux && !darwin && !freebsd
+
+package agent
+
+import "errors"
+
+// peerUID fails where aurl can't read the peer credentials, the agent then refuses every connection
+func peerUID(fd int) (int, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
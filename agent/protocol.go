@@ -0,0 +1,32 @@
+// Package agent implements a daemon holding credentials and tokens in memory, serving
+// keyring operations to aurl processes over a Unix socket (like ssh-agent).
+package agent
+
+import "github.com/byteness/keyring"
+
+// SocketEnvVar is the environment variable advertising the agent socket
+const SocketEnvVar = "AURL_AGENT_SOCK"
+
+const (
+	opPing   = "ping"
+	opGet    = "get"
+	opSet    = "set"
+	opRemove = "remove"
+	opKeys   = "keys"
+	opStop   = "stop"
+)
+
+// message is a single request sent to the agent, encoded as one line of JSON
+type message struct {
+	Op   string        `json:"op"`
+	Key  string        `json:"key,omitempty"`
+	Item *keyring.Item `json:"item,omitempty"`
+}
+
+// reply is the agent's answer to a message
+type reply struct {
+	Item     *keyring.Item `json:"item,omitempty"`
+	Keys     []string      `json:"keys,omitempty"`
+	NotFound bool          `json:"not_found,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
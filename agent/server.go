@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/vault"
+)
+
+const (
+	refreshInterval = 30 * time.Second
+	// RefreshMargin is how long before expiry the agent renews tokens
+	RefreshMargin = 5 * time.Minute
+
+	defaultSocketDirPrefix = "aurl-agent-"
+)
+
+// RefreshFunc renews the token stored under key in the keyring
+type RefreshFunc func(key vault.TokenCacheKey, keyring keyring.Keyring) error
+
+type Server struct {
+	SocketPath  string
+	Keyring     *CachingKeyring
+	IdleTimeout time.Duration
+	Refresh     RefreshFunc
+
+	listener net.Listener
+	// createdDir is set when Serve created the directory of the socket, to remove it on Stop
+	createdDir bool
+	activity   chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// DefaultSocketPath returns a fresh socket path inside a private temporary directory
+func DefaultSocketPath() (string, error) {
+	dir, err := os.MkdirTemp("", defaultSocketDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "agent.sock"), nil
+}
+
+// Serve listens on the socket and handles clients until the agent is stopped or idle for too long
+func (s *Server) Serve() error {
+	if err := s.prepareSocketDir(); err != nil {
+		return err
+	}
+	listener, err := listen(s.SocketPath)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.activity = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	slog.Info("agent listening", "socket", s.SocketPath)
+
+	go s.watchIdle()
+	if s.Refresh != nil {
+		go s.refreshLoop()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		if err := checkPeer(conn); err != nil {
+			slog.Warn("agent connection refused", "error", err)
+			conn.Close()
+			continue
+		}
+		select {
+		case s.activity <- struct{}{}:
+		default:
+		}
+		go s.handle(conn)
+	}
+}
+
+// Stop closes the listener and removes the socket
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		slog.Info("agent stopping")
+		close(s.done)
+		s.listener.Close()
+		os.Remove(s.SocketPath)
+		// remove the private directory created by Serve or DefaultSocketPath, fails harmlessly if not empty
+		if dir := filepath.Dir(s.SocketPath); s.createdDir || isDefaultSocketDir(dir) {
+			os.Remove(dir)
+		}
+	})
+}
+
+// prepareSocketDir creates the directory of the socket, private to the user. An existing directory is never
+// changed, only checked, so --socket can't open or break the permissions of a directory such as $HOME or /tmp.
+func (s *Server) prepareSocketDir() error {
+	dir := filepath.Dir(s.SocketPath)
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		s.createdDir = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Socket directory %s is not a directory", dir)
+	}
+	return checkSocketDir(dir, info)
+}
+
+// isDefaultSocketDir reports whether dir is a directory made by DefaultSocketPath
+func isDefaultSocketDir(dir string) bool {
+	return filepath.Dir(dir) == filepath.Clean(os.TempDir()) && strings.HasPrefix(filepath.Base(dir), defaultSocketDirPrefix)
+}
+
+func (s *Server) watchIdle() {
+	if s.IdleTimeout <= 0 {
+		return
+	}
+	timer := time.NewTimer(s.IdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.activity:
+			timer.Reset(s.IdleTimeout)
+		case <-timer.C:
+			slog.Info("agent idle", "idle_timeout", s.IdleTimeout.String())
+			s.Stop()
+			return
+		}
+	}
+}
+
+// refreshLoop renews the tokens held in memory before they expire
+func (s *Server) refreshLoop() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.refreshTokens()
+		}
+	}
+}
+
+func (s *Server) refreshTokens() {
+	for _, item := range s.Keyring.CachedItems() {
+		if !vault.IsTokenKeyName(item.Key) {
+			continue
+		}
+		var tokenInfo vault.TokenInfo
+		if err := json.Unmarshal(item.Data, &tokenInfo); err != nil || tokenInfo.CacheKey == nil {
+			continue
+		}
+		if !tokenInfo.ExpiresWithin(RefreshMargin) {
+			continue
+		}
+		slog.Info("agent token refresh", "key", tokenInfo.CacheKey.String())
+		if err := s.Refresh(*tokenInfo.CacheKey, s.Keyring); err != nil {
+			slog.Warn("agent token refresh failed", "key", tokenInfo.CacheKey.String(), "error", err)
+		}
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		slog.Warn("agent request read failed", "error", err)
+		return
+	}
+	var m message
+	var r reply
+	if err := json.Unmarshal(line, &m); err != nil {
+		r.Error = "invalid request: " + err.Error()
+	} else {
+		r = s.dispatch(m)
+	}
+	if err := json.NewEncoder(conn).Encode(r); err != nil {
+		slog.Warn("agent reply write failed", "error", err)
+	}
+	if m.Op == opStop {
+		s.Stop()
+	}
+}
+
+func (s *Server) dispatch(m message) (r reply) {
+	var err error
+	switch m.Op {
+	case opPing, opStop:
+	case opGet:
+		var item keyring.Item
+		if item, err = s.Keyring.Get(m.Key); err == nil {
+			r.Item = &item
+		}
+	case opSet:
+		if m.Item == nil {
+			err = errors.New("missing item")
+		} else {
+			err = s.Keyring.Set(*m.Item)
+		}
+	case opRemove:
+		err = s.Keyring.Remove(m.Key)
+	case opKeys:
+		r.Keys, err = s.Keyring.Keys()
+	default:
+		err = errors.New("unknown op: " + m.Op)
+	}
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		r.NotFound = true
+	} else if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
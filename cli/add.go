@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/byteness/keyring"
@@ -10,9 +14,34 @@ import (
 	"github.com/classmethod/aurl/vault"
 )
 
+// AddProfileSpec holds the settings and secrets of a new profile. It can be read from a JSON
+// file using the config key names, e.g. {"grant_type": "client_credentials", "client_id": "..."}
+type AddProfileSpec struct {
+	GrantType                string   `json:"grant_type"`
+	AuthzServerAuthEndpoint  string   `json:"auth_server_auth_endpoint"`
+	AuthzServerTokenEndpoint string   `json:"auth_server_token_endpoint"`
+	RedirectURI              string   `json:"redirect"`
+	Scope                    string   `json:"scopes"`
+	Audience                 string   `json:"audience"`
+	Resources                []string `json:"resource"`
+	ContentType              string   `json:"content_type"`
+	UserAgent                string   `json:"user_agent"`
+	Extends                  string   `json:"extends"`
+
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+}
+
 type AddCommandInput struct {
 	ProfileName string
 	force       bool
+
+	Spec              AddProfileSpec
+	FromFile          string
+	ClientSecretStdin bool
+	PasswordStdin     bool
 }
 
 func ConfigureAddCommand(app *kingpin.Application, a *Aurl) {
@@ -27,6 +56,58 @@ func ConfigureAddCommand(app *kingpin.Application, a *Aurl) {
 	cmd.Flag("force", "Force adding even if the profile already exists in the config file.").
 		BoolVar(&input.force)
 
+	cmd.Flag("from-file", "Read the profile settings and secrets from a JSON file using the config key names.").
+		PlaceHolder("SPEC.json").
+		StringVar(&input.FromFile)
+	cmd.Flag("grant-type", "OAuth2 grant type (authorization_code/implicit/password/client_credentials).").
+		Envar("AURL_GRANT_TYPE").
+		EnumVar(&input.Spec.GrantType, "authorization_code", "implicit", "password", "client_credentials")
+	cmd.Flag("auth-endpoint", "Authz server auth endpoint.").
+		Envar("AURL_AUTH_SERVER_AUTH_ENDPOINT").
+		StringVar(&input.Spec.AuthzServerAuthEndpoint)
+	cmd.Flag("token-endpoint", "Authz server token endpoint.").
+		Envar("AURL_AUTH_SERVER_TOKEN_ENDPOINT").
+		StringVar(&input.Spec.AuthzServerTokenEndpoint)
+	cmd.Flag("redirect-uri", "Redirect URI.").
+		Envar("AURL_REDIRECT").
+		StringVar(&input.Spec.RedirectURI)
+	cmd.Flag("scope", "Scopes (space separated).").
+		Envar("AURL_SCOPES").
+		StringVar(&input.Spec.Scope)
+	cmd.Flag("audience", "Audience of the requested tokens.").
+		Envar("AURL_AUDIENCE").
+		StringVar(&input.Spec.Audience)
+	cmd.Flag("resource", "Resource indicator of the requested tokens. Can be repeated.").
+		Envar("AURL_RESOURCE").
+		StringsVar(&input.Spec.Resources)
+	cmd.Flag("content-type", "Default content type.").
+		Envar("AURL_CONTENT_TYPE").
+		StringVar(&input.Spec.ContentType)
+	cmd.Flag("user-agent", "Default user agent.").
+		Envar("AURL_USER_AGENT").
+		StringVar(&input.Spec.UserAgent)
+	cmd.Flag("extends", "Profile to inherit settings from.").
+		Envar("AURL_EXTENDS").
+		StringVar(&input.Spec.Extends)
+	cmd.Flag("client-id", "Client ID.").
+		Envar("AURL_CLIENT_ID").
+		StringVar(&input.Spec.ClientId)
+	cmd.Flag("client-secret", "Client secret, better passed through the environment or --client-secret-stdin.").
+		Envar("AURL_CLIENT_SECRET").
+		Hidden().
+		StringVar(&input.Spec.ClientSecret)
+	cmd.Flag("client-secret-stdin", "Read the client secret from the first line of stdin.").
+		BoolVar(&input.ClientSecretStdin)
+	cmd.Flag("username", "Username for the password grant.").
+		Envar("AURL_USERNAME").
+		StringVar(&input.Spec.Username)
+	cmd.Flag("password", "Password, better passed through the environment or --password-stdin.").
+		Envar("AURL_PASSWORD").
+		Hidden().
+		StringVar(&input.Spec.Password)
+	cmd.Flag("password-stdin", "Read the password from stdin, from the second line if --client-secret-stdin is given too.").
+		BoolVar(&input.PasswordStdin)
+
 	cmd.Action(func(c *kingpin.ParseContext) error {
 		keyring, err := a.Keyring()
 		if err != nil {
@@ -42,93 +123,190 @@ func ConfigureAddCommand(app *kingpin.Application, a *Aurl) {
 	})
 }
 
-func AddCommand(input AddCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
-	if _, hasProfile := aurlConfigFile.ProfileSection(input.ProfileName); hasProfile && !input.force {
-		return fmt.Errorf("Profile %q already exists in config at %s (use --force to override)", input.ProfileName, aurlConfigFile.Path)
-	}
+// addField is a setting asked for by aurl add
+type addField struct {
+	value        *string
+	key          string
+	flag         string
+	prompt       string
+	defaultValue string
+	secret       bool
+	required     bool
+}
 
-	var grantType, authzServerAuthEndpoint, authzServerTokenEndpoint, redirectURI, clientId, clientSecret, username, password, scope, contentType, userAgent string
-	var err error
-
-	// Get grant type first to determine which fields are needed
-	if grantType, err = util.TerminalPromptWithDefault("Enter Grant Type (authorization_code/implicit/password/client_credentials, default: authorization_code): ", "authorization_code"); err != nil {
-		return err
-	}
+// addFields lists the settings needed by the grant type in prompt order
+func addFields(spec *AddProfileSpec) []addField {
+	clientId := addField{value: &spec.ClientId, key: "client_id", flag: "--client-id", prompt: "Enter Client ID: ", secret: true}
+	clientSecret := addField{value: &spec.ClientSecret, key: "client_secret", flag: "--client-secret-stdin", prompt: "Enter Client Secret: ", secret: true}
+	authEndpoint := addField{value: &spec.AuthzServerAuthEndpoint, key: "auth_server_auth_endpoint", flag: "--auth-endpoint", prompt: "Enter Authz Server Auth Endpoint: ", required: true}
+	tokenEndpoint := addField{value: &spec.AuthzServerTokenEndpoint, key: "auth_server_token_endpoint", flag: "--token-endpoint", prompt: "Enter Authz Server Token Endpoint: ", required: true}
+	redirect := addField{value: &spec.RedirectURI, key: "redirect", flag: "--redirect-uri", prompt: "Enter Redirect URI: ", required: true}
+	scope := addField{value: &spec.Scope, key: "scopes", flag: "--scope", prompt: "Enter Scopes (space separated): "}
 
 	// Common fields for all grant types
-	if clientId, err = util.TerminalSecretPrompt("Enter Client ID: "); err != nil {
-		return err
-	}
-	if clientSecret, err = util.TerminalSecretPrompt("Enter Client Secret: "); err != nil {
-		return err
-	}
+	fields := []addField{clientId, clientSecret}
 
 	// Grant type specific fields
-	switch grantType {
+	switch spec.GrantType {
 	case "authorization_code":
-		if authzServerAuthEndpoint, err = util.TerminalPrompt("Enter Authz Server Auth Endpoint: "); err != nil {
-			return err
-		}
-		if authzServerTokenEndpoint, err = util.TerminalPrompt("Enter Authz Server Token Endpoint: "); err != nil {
-			return err
-		}
-		if redirectURI, err = util.TerminalPrompt("Enter Redirect URI: "); err != nil {
-			return err
-		}
-		if scope, err = util.TerminalPrompt("Enter Scopes (space separated): "); err != nil {
-			return err
-		}
-
+		fields[0].required = true
+		fields = append(fields, authEndpoint, tokenEndpoint, redirect, scope)
 	case "implicit":
-		if authzServerAuthEndpoint, err = util.TerminalPrompt("Enter Authz Server Auth Endpoint: "); err != nil {
-			return err
-		}
-		if redirectURI, err = util.TerminalPrompt("Enter Redirect URI: "); err != nil {
-			return err
+		fields[0].required = true
+		fields = append(fields, authEndpoint, redirect, scope)
+	case "password":
+		fields = append(fields,
+			tokenEndpoint,
+			addField{value: &spec.Username, key: "username", flag: "--username", prompt: "Enter Username: ", required: true},
+			addField{value: &spec.Password, key: "password", flag: "--password-stdin", prompt: "Enter Password: ", secret: true, required: true},
+			scope)
+	case "client_credentials":
+		fields[0].required = true
+		fields[1].required = true
+		fields = append(fields, tokenEndpoint, scope)
+	}
+
+	// Common optional fields
+	return append(fields,
+		addField{value: &spec.ContentType, key: "content_type", flag: "--content-type", prompt: "Enter Content Type (default: application/json): ", defaultValue: "application/json"},
+		addField{value: &spec.UserAgent, key: "user_agent", flag: "--user-agent", prompt: "Enter User Agent (default: aurl): ", defaultValue: "aurl"},
+	)
+}
+
+// loadSpec merges the settings given by flags and environment over those of the spec file
+// and reads the secrets requested from stdin
+func loadSpec(input AddCommandInput) (AddProfileSpec, error) {
+	spec := AddProfileSpec{}
+	if input.FromFile != "" {
+		data, err := os.ReadFile(input.FromFile)
+		if err != nil {
+			return spec, err
 		}
-		if scope, err = util.TerminalPrompt("Enter Scopes (space separated): "); err != nil {
-			return err
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return spec, fmt.Errorf("Invalid profile spec %s: %w", input.FromFile, err)
 		}
+	}
 
-	case "password":
-		if authzServerTokenEndpoint, err = util.TerminalPrompt("Enter Authz Server Token Endpoint: "); err != nil {
-			return err
+	if err := mergeNonEmpty(&spec, input.Spec); err != nil {
+		return spec, err
+	}
+
+	if input.ClientSecretStdin || input.PasswordStdin {
+		var err error
+		stdin := bufio.NewReader(os.Stdin)
+		if input.ClientSecretStdin {
+			if spec.ClientSecret, err = readLine(stdin); err != nil {
+				return spec, fmt.Errorf("Error reading client secret from stdin: %w", err)
+			}
 		}
-		if username, err = util.TerminalPrompt("Enter Username: "); err != nil {
-			return err
+		if input.PasswordStdin {
+			if spec.Password, err = readLine(stdin); err != nil {
+				return spec, fmt.Errorf("Error reading password from stdin: %w", err)
+			}
 		}
-		if password, err = util.TerminalSecretPrompt("Enter Password: "); err != nil {
-			return err
+	}
+	return spec, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// mergeNonEmpty overwrites the fields of dst with the non-empty fields of src
+func mergeNonEmpty(dst *AddProfileSpec, src AddProfileSpec) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	nonEmpty := map[string]interface{}{}
+	if err := json.Unmarshal(data, &nonEmpty); err != nil {
+		return err
+	}
+	for key, value := range nonEmpty {
+		if value == nil || value == "" {
+			delete(nonEmpty, key)
 		}
-		if scope, err = util.TerminalPrompt("Enter Scopes (space separated): "); err != nil {
-			return err
+	}
+	if data, err = json.Marshal(nonEmpty); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func AddCommand(input AddCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	if _, hasProfile := aurlConfigFile.ProfileSection(input.ProfileName); hasProfile && !input.force {
+		return fmt.Errorf("Profile %q already exists in config at %s (use --force to override)", input.ProfileName, aurlConfigFile.Path)
+	}
+
+	spec, err := loadSpec(input)
+	if err != nil {
+		return err
+	}
+	interactive := util.IsTerminal()
+	// without any setting given, ask for everything like before
+	promptAll := interactive && spec.isEmpty()
+
+	// Get grant type first to determine which fields are needed
+	if spec.GrantType == "" {
+		if interactive {
+			if spec.GrantType, err = util.TerminalPromptWithDefault("Enter Grant Type (authorization_code/implicit/password/client_credentials, default: authorization_code): ", "authorization_code"); err != nil {
+				return err
+			}
+		} else {
+			spec.GrantType = "authorization_code"
 		}
+	}
+	switch spec.GrantType {
+	case "authorization_code", "implicit", "password", "client_credentials":
+	default:
+		return fmt.Errorf("Unknown grant type: %s", spec.GrantType)
+	}
 
-	case "client_credentials":
-		if authzServerTokenEndpoint, err = util.TerminalPrompt("Enter Authz Server Token Endpoint: "); err != nil {
+	// settings inherited from the extended profile or [default] need not be given again
+	inherited := map[string]bool{}
+	base := spec.Extends
+	if base == "" {
+		base = "default"
+	}
+	if _, hasBase := aurlConfigFile.ProfileSection(base); hasBase && base != input.ProfileName {
+		_, sources, err := vault.NewConfigLoader(aurlConfigFile, base).ResolveProfile(base)
+		if err != nil {
 			return err
 		}
-		if scope, err = util.TerminalPrompt("Enter Scopes (space separated): "); err != nil {
-			return err
+		for key := range sources {
+			inherited[key] = true
 		}
-
-	default:
-		return fmt.Errorf("Unknown grant type: %s", grantType)
 	}
 
-	// Common optional fields
-	if contentType, err = util.TerminalPromptWithDefault("Enter Content Type (default: application/json): ", "application/json"); err != nil {
-		return err
+	missing := []string{}
+	for _, field := range addFields(&spec) {
+		if *field.value != "" || (inherited[field.key] && !promptAll) {
+			continue
+		}
+		switch {
+		case promptAll || (field.required && interactive):
+			if *field.value, err = promptField(field); err != nil {
+				return err
+			}
+		case field.required:
+			missing = append(missing, fmt.Sprintf("%s (%s or AURL_%s)", field.key, field.flag, strings.ToUpper(field.key)))
+		default:
+			*field.value = field.defaultValue
+		}
 	}
-	if userAgent, err = util.TerminalPromptWithDefault("Enter User Agent (default: aurl): ", "aurl"); err != nil {
-		return err
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing required settings for grant type %s and no terminal to prompt for them: %s", spec.GrantType, strings.Join(missing, ", "))
 	}
 
 	creds := vault.Credentials{
-		ClientId:     clientId,
-		ClientSecret: clientSecret,
-		Username:     username,
-		Password:     password,
+		ClientId:     spec.ClientId,
+		ClientSecret: spec.ClientSecret,
+		Username:     spec.Username,
+		Password:     spec.Password,
 	}
 	ckr := &vault.CredentialKeyring{Keyring: keyring}
 	if err := ckr.Set(input.ProfileName, creds); err != nil {
@@ -138,24 +316,44 @@ func AddCommand(input AddCommandInput, keyring keyring.Keyring, aurlConfigFile *
 
 	newProfileSection := vault.ProfileSection{
 		Name:                    input.ProfileName,
-		GrantType:               grantType,
-		AuthServerAuthEndpoint:  authzServerAuthEndpoint,
-		AuthServerTokenEndpoint: authzServerTokenEndpoint,
-		Redirect:                redirectURI,
-		Scope:                   scope,
-		ContentType:             contentType,
-		UserAgent:               userAgent,
-	}
-	log.Printf("Adding profile %s to config at %s", input.ProfileName, aurlConfigFile.Path)
+		GrantType:               spec.GrantType,
+		AuthServerAuthEndpoint:  spec.AuthzServerAuthEndpoint,
+		AuthServerTokenEndpoint: spec.AuthzServerTokenEndpoint,
+		Redirect:                spec.RedirectURI,
+		Extends:                 spec.Extends,
+		Scope:                   spec.Scope,
+		Audience:                spec.Audience,
+		Resources:               spec.Resources,
+		ContentType:             spec.ContentType,
+		UserAgent:               spec.UserAgent,
+	}
+	slog.Debug("adding profile", "profile", input.ProfileName, "path", aurlConfigFile.Path)
 	if err := aurlConfigFile.Add(newProfileSection); err != nil {
 		return fmt.Errorf("Error adding profile: %w", err)
 	}
 
 	// Remove any existing tokens for the profile
 	tkr := &vault.TokenKeyring{Keyring: keyring}
-	if err := tkr.Remove(input.ProfileName); err != nil {
-		fmt.Printf("Warning: Failed to remove existing token for profile %q: %v\n", input.ProfileName, err)
+	if err := tkr.RemoveAll(input.ProfileName); err != nil {
+		fmt.Printf("Warning: Failed to remove existing tokens for profile %q: %v\n", input.ProfileName, err)
 	}
 
 	return nil
 }
+
+func (s AddProfileSpec) isEmpty() bool {
+	return s.GrantType == "" && s.AuthzServerAuthEndpoint == "" && s.AuthzServerTokenEndpoint == "" &&
+		s.RedirectURI == "" && s.Scope == "" && s.Audience == "" && len(s.Resources) == 0 &&
+		s.ContentType == "" && s.UserAgent == "" && s.Extends == "" &&
+		s.ClientId == "" && s.ClientSecret == "" && s.Username == "" && s.Password == ""
+}
+
+func promptField(field addField) (string, error) {
+	if field.secret {
+		return util.TerminalSecretPrompt(field.prompt)
+	}
+	if field.defaultValue != "" {
+		return util.TerminalPromptWithDefault(field.prompt, field.defaultValue)
+	}
+	return util.TerminalPrompt(field.prompt)
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/agent"
+	"github.com/classmethod/aurl/telemetry"
+	"github.com/classmethod/aurl/vault"
+)
+
+type AgentCommandInput struct {
+	SocketPath  string
+	IdleTimeout time.Duration
+}
+
+func ConfigureAgentCommand(app *kingpin.Application, a *Aurl) {
+	input := AgentCommandInput{}
+
+	cmd := app.Command("agent", "Run a background agent holding credentials and tokens in memory.")
+
+	start := cmd.Command("start", "Start the agent in the background and print the shell commands to use it.")
+	start.Flag("socket", "Path of the agent socket, in a directory owned by you with mode 0700. (default: a private temporary directory)").
+		StringVar(&input.SocketPath)
+	start.Flag("idle-timeout", "Stop the agent after this long without requests, 0 to never stop.").
+		Default("1h").
+		DurationVar(&input.IdleTimeout)
+	start.Action(func(c *kingpin.ParseContext) error {
+		kingpin.FatalIfError(AgentStartCommand(input, a), "agent start")
+		return nil
+	})
+
+	serve := cmd.Command("serve", "Run the agent in the foreground.").Hidden()
+	serve.Flag("socket", "Path of the agent socket.").
+		Required().
+		StringVar(&input.SocketPath)
+	serve.Flag("idle-timeout", "Stop the agent after this long without requests, 0 to never stop.").
+		Default("1h").
+		DurationVar(&input.IdleTimeout)
+	serve.Action(func(c *kingpin.ParseContext) error {
+		backend, err := a.backendKeyring()
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(AgentServeCommand(input, backend), "agent serve")
+		return nil
+	})
+
+	stop := cmd.Command("stop", fmt.Sprintf("Stop the agent advertised by %s.", agent.SocketEnvVar))
+	stop.Action(func(c *kingpin.ParseContext) error {
+		kingpin.FatalIfError(AgentStopCommand(), "agent stop")
+		return nil
+	})
+}
+
+func AgentStartCommand(input AgentCommandInput, a *Aurl) error {
+	socketPath := input.SocketPath
+	if socketPath == "" {
+		var err error
+		if socketPath, err = agent.DefaultSocketPath(); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"--backend", a.KeyringBackend, "agent", "serve", "--socket", socketPath, "--idle-timeout", input.IdleTimeout.String()}
+	pid, err := agent.StartDaemon(args, socketPath)
+	if err != nil {
+		if input.SocketPath == "" {
+			os.Remove(filepath.Dir(socketPath))
+		}
+		return err
+	}
+
+	fmt.Printf("%s=%s; export %s;\n", agent.SocketEnvVar, shellQuote(socketPath), agent.SocketEnvVar)
+	fmt.Printf("echo Agent pid %d;\n", pid)
+	return nil
+}
+
+// shellQuote quotes s for the shell evaluating the output of agent start
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func AgentServeCommand(input AgentCommandInput, backend keyring.Keyring) error {
+	server := &agent.Server{
+		SocketPath:  input.SocketPath,
+		Keyring:     agent.NewCachingKeyring(backend),
+		IdleTimeout: input.IdleTimeout,
+		Refresh:     refreshAgentToken,
+	}
+	return server.Serve()
+}
+
+// refreshAgentToken renews a token held by the agent, re-reading the config file so
+// profile changes made while the agent runs are picked up
+func refreshAgentToken(key vault.TokenCacheKey, keyring keyring.Keyring) error {
+	aurlConfigFile, err := vault.LoadConfig()
+	if err != nil {
+		return err
+	}
+	overrides := TokenOverrides{
+		Scope:     strings.Join(key.Scopes, " "),
+		Audience:  key.Audience,
+		Resources: key.Resources,
+	}
+	execution, err := newProfileRequest(key.Profile, overrides, false, keyring, aurlConfigFile)
+	if err != nil {
+		return err
+	}
+	insecure := false
+	execution.Insecure = &insecure
+	return execution.RefreshToken(telemetry.Context(), keyring, agent.RefreshMargin)
+}
+
+func AgentStopCommand() error {
+	socketPath := os.Getenv(agent.SocketEnvVar)
+	if socketPath == "" {
+		return fmt.Errorf("%s is not set", agent.SocketEnvVar)
+	}
+	if err := (&agent.Client{SocketPath: socketPath}).Stop(); err != nil {
+		return err
+	}
+	fmt.Printf("unset %s;\n", agent.SocketEnvVar)
+	fmt.Println("echo Agent stopped;")
+	return nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/request"
+	"github.com/classmethod/aurl/vault"
+)
+
+type BatchCommandInput struct {
+	ProfileName  string
+	RenewToken   bool
+	Overrides    TokenOverrides
+	Insecure     bool
+	AllowAnyHost bool
+	Parallel     int
+	Rate         string
+	OutputFile   string
+
+	File string
+}
+
+func ConfigureBatchCommand(app *kingpin.Application, a *Aurl) {
+	input := BatchCommandInput{}
+
+	cmd := app.Command("batch", "Execute the requests of a JSON lines file with a single token and write their results as JSON lines.")
+
+	cmd.Arg("profile", "Name of the profile to use, may be omitted if $"+vault.ProfileEnvVar+" is set.").
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+	cmd.Arg("file", "JSON lines file of requests with method, url, headers and body, - for stdin.").
+		StringVar(&input.File)
+	cmd.Flag("renew-token", "Force renewal of access token even if a valid token exists in the keyring.").
+		BoolVar(&input.RenewToken)
+	configureTokenOverrides(cmd, &input.Overrides)
+
+	cmd.Flag("parallel", "Number of requests to send at the same time.").
+		Short('P').
+		Default("1").
+		IntVar(&input.Parallel)
+	cmd.Flag("rate", "Send at most this many requests per second, minute or hour, such as 10/s.").
+		PlaceHolder("N/s").
+		StringVar(&input.Rate)
+	cmd.Flag("output", "Write the results to FILE instead of stdout.").
+		Short('o').
+		PlaceHolder("FILE").
+		StringVar(&input.OutputFile)
+	cmd.Flag("insecure", "Disable SSL certificate verification.").
+		Short('k').
+		BoolVar(&input.Insecure)
+	cmd.Flag("allow-any-host", "Send the access token to hosts not matching allowed_hosts of the profile.").
+		BoolVar(&input.AllowAnyHost)
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(BatchCommand(input, keyring, aurlConfigFile), "batch")
+		return nil
+	})
+}
+
+func BatchCommand(input BatchCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) (err error) {
+	// a single argument is the file if it is - or an existing file, the profile then comes from AURL_PROFILE
+	if input.File == "" && input.ProfileName != "" {
+		info, statErr := os.Stat(input.ProfileName)
+		isFile := input.ProfileName == "-" || (statErr == nil && !info.IsDir())
+		if isFile, err = targetOrProfile(input.ProfileName, "file", isFile, aurlConfigFile); err != nil {
+			return err
+		}
+		if isFile {
+			input.ProfileName, input.File = "", input.ProfileName
+		}
+	}
+	if input.File == "" {
+		return fmt.Errorf("required argument 'file' not provided")
+	}
+	if input.ProfileName, err = profileOrDefault(input.ProfileName); err != nil {
+		return err
+	}
+	if input.Parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	var rate *request.Rate
+	if input.Rate != "" {
+		parsed, err := request.ParseRate(input.Rate)
+		if err != nil {
+			return err
+		}
+		rate = &parsed
+	}
+
+	var requests io.Reader = os.Stdin
+	if input.File != "-" {
+		f, err := os.Open(input.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		requests = f
+	}
+	var output io.Writer = os.Stdout
+	if input.OutputFile != "" {
+		f, err := os.OpenFile(input.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+
+	execution, err := newProfileRequest(input.ProfileName, input.Overrides, input.RenewToken, keyring, aurlConfigFile)
+	if err != nil {
+		return err
+	}
+	execution.Insecure = &input.Insecure
+	execution.AllowAnyHost = &input.AllowAnyHost
+
+	return execution.ExecuteBatch(keyring, requests, output, input.Parallel, rate)
+}
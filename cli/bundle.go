@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/util"
+	"github.com/classmethod/aurl/vault"
+)
+
+const bundlePassphraseEnvVar = "AURL_BUNDLE_PASSPHRASE"
+
+type ExportCommandInput struct {
+	ProfileNames []string
+	Output       string
+	WithSecrets  bool
+}
+
+type ImportCommandInput struct {
+	File  string
+	Force bool
+}
+
+func ConfigureBundleCommands(app *kingpin.Application, a *Aurl) {
+	exportInput := ExportCommandInput{}
+	importInput := ImportCommandInput{}
+
+	export := app.Command("export", "Export profiles to a bundle file that can be shared with aurl import.")
+	export.Arg("profiles", "Names of the profiles to export.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringsVar(&exportInput.ProfileNames)
+	export.Flag("output", "Write the bundle to the file instead of stdout.").
+		Short('o').
+		StringVar(&exportInput.Output)
+	export.Flag("with-secrets", "Include the credentials, encrypted with a passphrase taken from "+bundlePassphraseEnvVar+" or prompted for.").
+		BoolVar(&exportInput.WithSecrets)
+
+	export.Action(func(c *kingpin.ParseContext) error {
+		var kr keyring.Keyring
+		var err error
+		if exportInput.WithSecrets {
+			if kr, err = a.Keyring(); err != nil {
+				return err
+			}
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(ExportCommand(exportInput, kr, aurlConfigFile), "export")
+		return nil
+	})
+
+	imp := app.Command("import", "Import the profiles of a bundle written by aurl export.")
+	imp.Arg("file", "Bundle file, - for stdin.").
+		Required().
+		StringVar(&importInput.File)
+	imp.Flag("force", "Replace existing profiles of the same name.").
+		BoolVar(&importInput.Force)
+
+	imp.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(ImportCommand(importInput, keyring, aurlConfigFile), "import")
+		return nil
+	})
+}
+
+func ExportCommand(input ExportCommandInput, kr keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	bundle := vault.NewBundle()
+	credentials := map[string]vault.Credentials{}
+	exported := map[string]bool{}
+	for _, name := range input.ProfileNames {
+		if exported[name] {
+			continue
+		}
+		exported[name] = true
+		text, err := aurlConfigFile.SectionText(name)
+		if err != nil {
+			return err
+		}
+		bundle.Profiles = append(bundle.Profiles, vault.BundleProfile{Name: name, Config: text})
+
+		section, _ := aurlConfigFile.ProfileSection(name)
+		if parent := section.Parent(); parent != "" && !slices.Contains(input.ProfileNames, parent) {
+			fmt.Fprintf(os.Stderr, "Warning: Profile %q extends %q, which is not exported\n", name, parent)
+		}
+
+		if input.WithSecrets {
+			creds, err := (&vault.CredentialKeyring{Keyring: kr}).Get(name)
+			if errors.Is(err, vault.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("Failed to get credentials of profile %q: %w", name, err)
+			}
+			credentials[name] = *creds
+		}
+	}
+
+	if input.WithSecrets {
+		passphrase, err := bundlePassphrase(true)
+		if err != nil {
+			return err
+		}
+		if err := bundle.EncryptCredentials(credentials, passphrase); err != nil {
+			return fmt.Errorf("Error encrypting credentials: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if input.Output == "" || input.Output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(input.Output, data, 0600); err != nil {
+		return fmt.Errorf("Error writing bundle: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d profile(s) to %s\n", len(bundle.Profiles), input.Output)
+	return nil
+}
+
+func ImportCommand(input ImportCommandInput, kr keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	var data []byte
+	var err error
+	if input.File == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(input.File)
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading bundle: %w", err)
+	}
+	bundle, err := vault.ParseBundle(data)
+	if err != nil {
+		return err
+	}
+
+	if !input.Force {
+		for _, profile := range bundle.Profiles {
+			if _, exists := aurlConfigFile.ProfileSection(profile.Name); exists {
+				return fmt.Errorf("Profile %q already exists in config at %s, use --force to replace it", profile.Name, aurlConfigFile.Path)
+			}
+		}
+	}
+
+	credentials := map[string]vault.Credentials{}
+	if bundle.Credentials != nil {
+		passphrase, err := bundlePassphrase(false)
+		if err != nil {
+			return err
+		}
+		if credentials, err = bundle.DecryptCredentials(passphrase); err != nil {
+			return err
+		}
+	}
+
+	for _, profile := range bundle.Profiles {
+		problems, err := aurlConfigFile.AddSectionText(profile.Name, profile.Config)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Fprintf(os.Stderr, "%s\n", problem)
+			}
+			return fmt.Errorf("Profile %q in bundle is invalid, nothing was imported", profile.Name)
+		}
+	}
+	if err := aurlConfigFile.Save(); err != nil {
+		return fmt.Errorf("Error saving config: %w", err)
+	}
+
+	ckr := &vault.CredentialKeyring{Keyring: kr}
+	tkr := &vault.TokenKeyring{Keyring: kr}
+	for _, profile := range bundle.Profiles {
+		if creds, ok := credentials[profile.Name]; ok {
+			if err := ckr.Set(profile.Name, creds); err != nil {
+				return fmt.Errorf("Error storing credentials in keyring: %w", err)
+			}
+			fmt.Printf("Imported profile %q with credentials\n", profile.Name)
+		} else {
+			fmt.Printf("Imported profile %q, set its credentials with: aurl credentials set %s\n", profile.Name, profile.Name)
+		}
+		if input.Force {
+			if err := tkr.RemoveAll(profile.Name); err != nil {
+				fmt.Printf("Warning: Failed to remove existing tokens for profile %q: %v\n", profile.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// bundlePassphrase returns the passphrase from the environment, or prompts for it
+func bundlePassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(bundlePassphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+	if !util.IsTerminal() {
+		return "", fmt.Errorf("No terminal to prompt for the bundle passphrase, set %s", bundlePassphraseEnvVar)
+	}
+	passphrase, err := util.TerminalSecretPrompt("Enter bundle passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("Empty passphrase")
+	}
+	if confirm {
+		again, err := util.TerminalSecretPrompt("Enter bundle passphrase again: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("Passphrases don't match")
+		}
+	}
+	return passphrase, nil
+}
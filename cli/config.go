@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/vault"
+)
+
+type ConfigSetCommandInput struct {
+	ProfileName string
+	Settings    []string
+}
+
+type ConfigUnsetCommandInput struct {
+	ProfileName string
+	Keys        []string
+}
+
+type ConfigShowCommandInput struct {
+	ProfileName string
+	Resolved    bool
+}
+
+func ConfigureConfigCommand(app *kingpin.Application, a *Aurl) {
+	cmd := app.Command("config", "Inspect and change the config file.")
+
+	showInput := ConfigShowCommandInput{}
+	show := cmd.Command("show", "Show the settings of a profile.")
+	show.Arg("profile", "Name of the profile to show.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringVar(&showInput.ProfileName)
+	show.Flag("resolved", "Show the effective settings after inheritance, with the profile each value comes from.").
+		BoolVar(&showInput.Resolved)
+	show.Action(func(c *kingpin.ParseContext) error {
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(ConfigShowCommand(showInput, aurlConfigFile), "config show")
+		return nil
+	})
+
+	setInput := ConfigSetCommandInput{}
+	set := cmd.Command("set", "Set keys of a profile. Nested values are set as extra_token_params.NAME=VALUE.")
+	set.Arg("profile", "Name of the profile to change.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringVar(&setInput.ProfileName)
+	set.Arg("settings", "Settings as KEY=VALUE, repeat the key for multi-valued keys.").
+		Required().
+		StringsVar(&setInput.Settings)
+	set.Action(func(c *kingpin.ParseContext) error {
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+		keys := []string{}
+		for _, setting := range setInput.Settings {
+			key, _, _ := strings.Cut(setting, "=")
+			keys = append(keys, key)
+		}
+		keyring, err := keyringIfTokensAffected(a, keys)
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(ConfigSetCommand(setInput, keyring, aurlConfigFile), "config set")
+		return nil
+	})
+
+	unsetInput := ConfigUnsetCommandInput{}
+	unset := cmd.Command("unset", "Remove keys from a profile.")
+	unset.Arg("profile", "Name of the profile to change.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringVar(&unsetInput.ProfileName)
+	unset.Arg("keys", "Keys to remove.").
+		Required().
+		StringsVar(&unsetInput.Keys)
+	unset.Action(func(c *kingpin.ParseContext) error {
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+		keyring, err := keyringIfTokensAffected(a, unsetInput.Keys)
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(ConfigUnsetCommand(unsetInput, keyring, aurlConfigFile), "config unset")
+		return nil
+	})
+
+	configureConfigValidateCommand(cmd, a)
+}
+
+// keyringIfTokensAffected opens the keyring only if changing the keys invalidates cached tokens
+func keyringIfTokensAffected(a *Aurl, keys []string) (keyring.Keyring, error) {
+	for _, key := range keys {
+		if vault.AffectsTokens(strings.ToLower(key)) {
+			return a.Keyring()
+		}
+	}
+	return nil, nil
+}
+
+func ConfigSetCommand(input ConfigSetCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	keys := []string{}
+	values := map[string][]string{}
+	for _, setting := range input.Settings {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return fmt.Errorf("Invalid setting %q, expected KEY=VALUE", setting)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], strings.TrimSpace(value))
+	}
+
+	for _, key := range keys {
+		if err := aurlConfigFile.SetKey(input.ProfileName, key, values[key]); err != nil {
+			return err
+		}
+	}
+	if err := checkChangedProfile(aurlConfigFile, input.ProfileName); err != nil {
+		return err
+	}
+	if err := aurlConfigFile.Save(); err != nil {
+		return fmt.Errorf("Error saving config: %w", err)
+	}
+	fmt.Printf("Updated profile %q in config at %s\n", input.ProfileName, aurlConfigFile.Path)
+
+	if keyring != nil {
+		invalidateTokens(keyring, aurlConfigFile, input.ProfileName)
+	}
+	return nil
+}
+
+func ConfigUnsetCommand(input ConfigUnsetCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	changed := false
+	for _, key := range input.Keys {
+		removed, err := aurlConfigFile.UnsetKey(input.ProfileName, key)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			fmt.Printf("Key %q is not set in profile %q\n", key, input.ProfileName)
+		}
+		changed = changed || removed
+	}
+	if !changed {
+		return nil
+	}
+	if err := checkChangedProfile(aurlConfigFile, input.ProfileName); err != nil {
+		return err
+	}
+	if err := aurlConfigFile.Save(); err != nil {
+		return fmt.Errorf("Error saving config: %w", err)
+	}
+	fmt.Printf("Updated profile %q in config at %s\n", input.ProfileName, aurlConfigFile.Path)
+
+	if keyring != nil {
+		invalidateTokens(keyring, aurlConfigFile, input.ProfileName)
+	}
+	return nil
+}
+
+// changedProfileProblems validates the profile as changed in memory, with the checks of config validate.
+// Values set by environment variables aren't part of the change, and lines aren't known until the file is saved.
+func changedProfileProblems(aurlConfigFile *vault.ConfigFile, profileName string) []vault.KeyProblem {
+	// profiles extended by others, and [default], may only hold shared settings
+	base := profileName == "default" || len(aurlConfigFile.Dependents(profileName)) > 1
+	problems := []vault.KeyProblem{}
+	for _, problem := range vault.NewConfigLoader(aurlConfigFile, profileName).ValidateProfile(profileName, base) {
+		if strings.HasPrefix(problem.Path, "$") {
+			continue
+		}
+		problem.Line = 0
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// checkChangedProfile refuses a change leaving the profile invalid, reporting its problems
+func checkChangedProfile(aurlConfigFile *vault.ConfigFile, profileName string) error {
+	problems := changedProfileProblems(aurlConfigFile, profileName)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return errors.New("Invalid profile, config unchanged")
+	}
+	return nil
+}
+
+// invalidateTokens removes the cached tokens of the profile and of the profiles inheriting from it
+func invalidateTokens(keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile, profileName string) {
+	tkr := &vault.TokenKeyring{Keyring: keyring}
+	for _, name := range aurlConfigFile.Dependents(profileName) {
+		if err := tkr.RemoveAll(name); err != nil {
+			fmt.Printf("Warning: Failed to remove existing tokens for profile %q: %v\n", name, err)
+		} else {
+			slog.Debug("removed cached tokens", "profile", name)
+		}
+	}
+}
+
+func ConfigShowCommand(input ConfigShowCommandInput, aurlConfigFile *vault.ConfigFile) error {
+	if !input.Resolved {
+		profile, ok := aurlConfigFile.ProfileSection(input.ProfileName)
+		if !ok {
+			return fmt.Errorf("Profile '%s' not found in config file", input.ProfileName)
+		}
+		writeProfile(os.Stdout, profile, nil)
+		return nil
+	}
+
+	profile, sources, err := vault.NewConfigLoader(aurlConfigFile, input.ProfileName).EffectiveProfile(input.ProfileName)
+	if err != nil {
+		return err
+	}
+	writeProfile(os.Stdout, profile, sources)
+	return nil
+}
+
+// writeProfile prints the non-empty keys of the profile in ini format, annotated with
+// the section each value comes from when sources is given
+func writeProfile(w io.Writer, profile vault.ProfileSection, sources map[string]string) {
+	fmt.Fprintf(w, "[%s]\n", profile.Name)
+	for _, field := range vault.ProfileFields() {
+		if !field.IsSet(profile) {
+			continue
+		}
+		lines := formatProfileValue(field.Key, field.Value(profile))
+		if source, ok := sources[field.Key]; ok {
+			lines[0] = fmt.Sprintf("%-50s ; from %s", lines[0], source)
+		}
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+}
+
+func formatProfileValue(key string, value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		lines := []string{}
+		for _, item := range v {
+			lines = append(lines, fmt.Sprintf("%s = %s", key, item))
+		}
+		return lines
+	case map[string]string:
+		lines := []string{key + " ="}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("  %s = %s", name, v[name]))
+		}
+		return lines
+	default:
+		return []string{fmt.Sprintf("%s = %v", key, v)}
+	}
+}
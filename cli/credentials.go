@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/util"
+	"github.com/classmethod/aurl/vault"
+)
+
+type CredentialsSetCommandInput struct {
+	ProfileName       string
+	ClientId          string
+	Username          string
+	ClientSecretStdin bool
+	PasswordStdin     bool
+}
+
+func ConfigureCredentialsCommand(app *kingpin.Application, a *Aurl) {
+	input := CredentialsSetCommandInput{}
+
+	cmd := app.Command("credentials", "Manage the credentials of a profile in the keyring.")
+
+	set := cmd.Command("set", "Replace the client secret, password or other credentials of a profile. Prompts for the client secret and password if no flag is given.")
+	set.Arg("profile", "Name of the profile.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+	set.Flag("client-id", "New client ID.").
+		StringVar(&input.ClientId)
+	set.Flag("username", "New username.").
+		StringVar(&input.Username)
+	set.Flag("client-secret-stdin", "Read the new client secret from the first line of stdin.").
+		BoolVar(&input.ClientSecretStdin)
+	set.Flag("password-stdin", "Read the new password from stdin, from the second line if --client-secret-stdin is given too.").
+		BoolVar(&input.PasswordStdin)
+
+	set.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(CredentialsSetCommand(input, keyring, aurlConfigFile), "credentials set")
+		return nil
+	})
+}
+
+func CredentialsSetCommand(input CredentialsSetCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	// credentials may be fixed before the rest of the profile, so it isn't validated
+	profile, _, err := vault.NewConfigLoader(aurlConfigFile, input.ProfileName).EffectiveProfile(input.ProfileName)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+	ckr := &vault.CredentialKeyring{Keyring: keyring}
+	creds, err := ckr.Get(input.ProfileName)
+	if errors.Is(err, vault.ErrNotFound) {
+		// e.g. a profile imported without secrets
+		creds, err = &vault.Credentials{}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get credentials: %w", err)
+	}
+
+	if input.ClientId != "" {
+		creds.ClientId = input.ClientId
+	}
+	if input.Username != "" {
+		creds.Username = input.Username
+	}
+	if input.ClientSecretStdin || input.PasswordStdin {
+		stdin := bufio.NewReader(os.Stdin)
+		if input.ClientSecretStdin {
+			if creds.ClientSecret, err = readLine(stdin); err != nil {
+				return fmt.Errorf("Error reading client secret from stdin: %w", err)
+			}
+		}
+		if input.PasswordStdin {
+			if creds.Password, err = readLine(stdin); err != nil {
+				return fmt.Errorf("Error reading password from stdin: %w", err)
+			}
+		}
+	} else if input.ClientId == "" && input.Username == "" {
+		if !util.IsTerminal() {
+			return fmt.Errorf("No credentials given and no terminal to prompt for them")
+		}
+		secret, err := util.TerminalSecretPrompt("Enter new Client Secret (empty to keep the current one): ")
+		if err != nil {
+			return err
+		}
+		if secret != "" {
+			creds.ClientSecret = secret
+		}
+		if profile.GrantType == "password" {
+			password, err := util.TerminalSecretPrompt("Enter new Password (empty to keep the current one): ")
+			if err != nil {
+				return err
+			}
+			if password != "" {
+				creds.Password = password
+			}
+		}
+	}
+
+	if err := ckr.Set(input.ProfileName, *creds); err != nil {
+		return fmt.Errorf("Error storing credentials in keyring: %w", err)
+	}
+	fmt.Printf("Updated credentials of profile %q in vault\n", input.ProfileName)
+
+	// Tokens were issued to the previous credentials
+	tkr := &vault.TokenKeyring{Keyring: keyring}
+	if err := tkr.RemoveAll(input.ProfileName); err != nil {
+		fmt.Printf("Warning: Failed to remove existing tokens for profile %q: %v\n", input.ProfileName, err)
+	}
+	return nil
+}
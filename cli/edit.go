@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/classmethod/aurl/util"
+	"github.com/classmethod/aurl/vault"
+)
+
+type EditCommandInput struct {
+	ProfileName string
+}
+
+func ConfigureEditCommand(app *kingpin.Application, a *Aurl) {
+	input := EditCommandInput{}
+
+	cmd := app.Command("edit", "Edit a profile in $EDITOR.")
+
+	cmd.Arg("profile", "Name of the profile to edit.").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+
+	cmd.Action(func(c *kingpin.ParseContext) error {
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		tokensAffected, err := EditCommand(input, aurlConfigFile)
+		kingpin.FatalIfError(err, "edit")
+		if tokensAffected {
+			keyring, err := a.Keyring()
+			if err != nil {
+				return err
+			}
+			invalidateTokens(keyring, aurlConfigFile, input.ProfileName)
+		}
+		return nil
+	})
+}
+
+// EditCommand lets the user edit the profile section in an editor and saves it once it's valid.
+// Returns whether settings affecting the cached tokens changed.
+func EditCommand(input EditCommandInput, aurlConfigFile *vault.ConfigFile) (bool, error) {
+	before, ok := aurlConfigFile.ProfileSection(input.ProfileName)
+	if !ok {
+		return false, fmt.Errorf("Profile '%s' not found in config file", input.ProfileName)
+	}
+	text, err := aurlConfigFile.SectionText(input.ProfileName)
+	if err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp("", "aurl-"+tempFileName(input.ProfileName)+"-*.ini")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	tmp.Close()
+
+	for {
+		if err := runEditor(tmp.Name()); err != nil {
+			return false, err
+		}
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return false, err
+		}
+		if string(edited) == text {
+			fmt.Println("No changes")
+			return false, nil
+		}
+
+		problems, err := aurlConfigFile.ReplaceSection(input.ProfileName, string(edited))
+		if err != nil {
+			return false, err
+		}
+		if len(problems) == 0 {
+			problems = changedProfileProblems(aurlConfigFile, input.ProfileName)
+		}
+		if len(problems) == 0 {
+			break
+		}
+
+		for _, problem := range problems {
+			problem.Path = tmp.Name()
+			fmt.Fprintf(os.Stderr, "Error: %s\n", problem)
+		}
+		if !util.IsTerminal() {
+			return false, errors.New("Invalid profile, config unchanged")
+		}
+		answer, err := util.TerminalPromptWithDefault("Edit again? [Y/n]: ", "y")
+		if err != nil {
+			return false, err
+		}
+		if !strings.HasPrefix(strings.ToLower(answer), "y") {
+			return false, errors.New("Invalid profile, config unchanged")
+		}
+	}
+
+	if err := aurlConfigFile.Save(); err != nil {
+		return false, fmt.Errorf("Error saving config: %w", err)
+	}
+	fmt.Printf("Updated profile %q in config at %s\n", input.ProfileName, aurlConfigFile.Path)
+
+	after, _ := aurlConfigFile.ProfileSection(input.ProfileName)
+	return vault.TokenSettingsChanged(before, after), nil
+}
+
+// tempFileName replaces the characters of the profile name which can't be in a file name, such as
+// the path separator or the * of os.CreateTemp patterns
+func tempFileName(profileName string) string {
+	return strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, profileName)
+}
+
+// runEditor opens the file in $VISUAL or $EDITOR
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error running editor %q: %w", editor, err)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package cli
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"strings"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/pretty"
 	"github.com/classmethod/aurl/request"
+	"github.com/classmethod/aurl/util"
 	"github.com/classmethod/aurl/vault"
 )
 
@@ -15,14 +18,26 @@ type ExecCommandInput struct {
 	// for aurl options
 	ProfileName string
 	RenewToken  bool
+	Overrides   TokenOverrides
 
 	// for curl options
 	Method       string
 	Headers      []string
 	Data         string
 	Insecure     bool
+	AllowAnyHost bool
 	PrintBody    bool
 	PrintHeaders bool
+	Timing       bool
+	WriteOut     string
+	HARFile      string
+	Pretty       bool
+	Raw          bool
+	JQ           string
+	Paginate     bool
+	Pagination   request.Pagination
+	Stream       request.Stream
+	Version      string
 
 	TargetUrl string
 }
@@ -32,12 +47,12 @@ func ConfigureExecCommand(app *kingpin.Application, a *Aurl) {
 
 	cmd := app.Command("exec", "Execute a command with Token.")
 
-	cmd.Arg("profile", "Name of the profile to use.").
-		Required().
+	cmd.Arg("profile", "Name of the profile to use, may be omitted if $"+vault.ProfileEnvVar+" is set.").
 		HintAction(a.MustGetProfileNames).
 		StringVar(&input.ProfileName)
 	cmd.Flag("renew-token", "Force renewal of access token even if a valid token exists in the keyring.").
 		BoolVar(&input.RenewToken)
+	configureTokenOverrides(cmd, &input.Overrides)
 
 	cmd.Flag("request", "Set HTTP request method. (default: \"GET\")").
 		Short('X').
@@ -53,14 +68,58 @@ func ConfigureExecCommand(app *kingpin.Application, a *Aurl) {
 	cmd.Flag("insecure", "Disable SSL certificate verification.").
 		Short('k').
 		BoolVar(&input.Insecure)
+	cmd.Flag("allow-any-host", "Send the access token to hosts not matching allowed_hosts of the profile.").
+		BoolVar(&input.AllowAnyHost)
 	cmd.Flag("print-body", "Enable printing response body to stdout. (default: enabled, try --no-print-body)").
 		Default("true").
 		BoolVar(&input.PrintBody)
 	cmd.Flag("print-headers", "Enable printing response headers JSON to stdout. (default: disabled, try --no-print-headers)").
 		BoolVar(&input.PrintHeaders)
-
-	cmd.Arg("url", "The URL to request").
-		Required().
+	cmd.Flag("timing", "Print the DNS, connect, TLS, first byte and total times of the token and resource requests to stderr.").
+		BoolVar(&input.Timing)
+	cmd.Flag("write-out", "Print FORMAT to stdout after the response, replacing %{http_code}, %{url_effective}, %{content_type}, %{time_namelookup}, %{time_connect}, %{time_appconnect}, %{time_starttransfer}, %{time_total} and %{time_token} like curl.").
+		Short('w').
+		PlaceHolder("FORMAT").
+		StringVar(&input.WriteOut)
+
+	cmd.Flag("pretty", "Indent and colour JSON and XML responses even if stdout is not a terminal.").
+		BoolVar(&input.Pretty)
+	cmd.Flag("raw", "Print responses as received even if stdout is a terminal.").
+		BoolVar(&input.Raw)
+	cmd.Flag("jq", "Filter JSON responses with a jq expression.").
+		PlaceHolder("EXPR").
+		StringVar(&input.JQ)
+
+	cmd.Flag("paginate", "Request the next pages given by the Link header or --next-field and print their items as one JSON array.").
+		BoolVar(&input.Paginate)
+	cmd.Flag("next-field", "Dotted path of the JSON field holding the URL or cursor of the next page. Implies --paginate.").
+		PlaceHolder("PATH").
+		StringVar(&input.Pagination.NextField)
+	cmd.Flag("items-field", "Dotted path of the JSON array holding the items of a page. Implies --paginate.").
+		PlaceHolder("PATH").
+		StringVar(&input.Pagination.ItemsField)
+	cmd.Flag("cursor-param", "Query parameter to send a cursor of --next-field in.").
+		Default("cursor").
+		StringVar(&input.Pagination.CursorParam)
+	cmd.Flag("max-pages", "Stop after this many pages. (default: no limit)").
+		IntVar(&input.Pagination.MaxPages)
+	cmd.Flag("ndjson", "Print the items of the pages as they arrive, one JSON document per line. Implies --paginate.").
+		BoolVar(&input.Pagination.NDJSON)
+
+	cmd.Flag("no-buffer", "Print the response body as it arrives, without pretty printing it. Server-sent events and NDJSON are always printed as they arrive.").
+		Short('N').
+		BoolVar(&input.Stream.NoBuffer)
+	cmd.Flag("sse-json", "Print server-sent events as JSON lines with their id, event and data.").
+		BoolVar(&input.Stream.JSON)
+	cmd.Flag("reconnect", "Reopen a server-sent events stream when it ends, with the Last-Event-ID header and a renewed token if needed, up to N times in a row without events.").
+		PlaceHolder("N").
+		IntVar(&input.Stream.Reconnect)
+
+	cmd.Flag("har", "Write the token and resource requests, with secrets masked, to FILE as an HTTP Archive.").
+		PlaceHolder("FILE").
+		StringVar(&input.HARFile)
+
+	cmd.Arg("url", "The URL to request, or a path appended to the base_url of the profile.").
 		StringVar(&input.TargetUrl)
 
 	cmd.Action(func(c *kingpin.ParseContext) (err error) {
@@ -73,50 +132,82 @@ func ConfigureExecCommand(app *kingpin.Application, a *Aurl) {
 			return err
 		}
 
+		input.Version = app.Model().Version
 		kingpin.FatalIfError(ExecCommand(input, keyring, aurlConfigFile), "exec")
 		return nil
 	})
 }
 
 func ExecCommand(input ExecCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) (err error) {
-
-	config, err := vault.NewConfigLoader(aurlConfigFile, input.ProfileName).GetProfileConfig(input.ProfileName)
-	if err != nil {
-		return fmt.Errorf("Error loading config: %w", err)
+	// a single argument is the URL if it looks like one, the profile then comes from AURL_PROFILE
+	if input.TargetUrl == "" && input.ProfileName != "" {
+		isURL := strings.Contains(input.ProfileName, "://") || strings.HasPrefix(input.ProfileName, "/")
+		if isURL, err = targetOrProfile(input.ProfileName, "URL", isURL, aurlConfigFile); err != nil {
+			return err
+		}
+		if isURL {
+			input.ProfileName, input.TargetUrl = "", input.ProfileName
+		}
 	}
-
-	ckr := &vault.CredentialKeyring{Keyring: keyring}
-	creds, credsErr := ckr.Get(input.ProfileName)
-	if credsErr != nil {
-		return fmt.Errorf("Failed to get credentials: %w", credsErr)
+	if input.TargetUrl == "" {
+		return fmt.Errorf("required argument 'url' not provided, give a URL or a path starting with /")
 	}
-
-	var tokenInfo *vault.TokenInfo
-	// Load previous token from keyring unless RenewToken is specified
-	if !input.RenewToken {
-		tkr := &vault.TokenKeyring{Keyring: keyring}
-		if tokenInfo, err = tkr.Get(input.ProfileName); err != nil {
-			log.Printf("Previous token not found in keyring: %v", err.Error())
+	if input.ProfileName, err = profileOrDefault(input.ProfileName); err != nil {
+		return err
+	}
+	if input.Pretty && input.Raw {
+		return fmt.Errorf("--pretty and --raw can't be used together")
+	}
+	var filter *pretty.Filter
+	if input.JQ != "" {
+		if filter, err = pretty.CompileFilter(input.JQ); err != nil {
+			return err
 		}
 	}
+	terminal := util.IsStdoutTerminal()
+	printPretty := input.Pretty || (terminal && !input.Raw)
+	color := printPretty && os.Getenv("NO_COLOR") == ""
 
-	execution := &request.Request{
-		Name: input.ProfileName,
-
-		Config:       config,
-		Credentials:  creds,
-		TokenInfo:    tokenInfo,
-		Method:       &input.Method,
-		Headers:      &input.Headers,
-		Data:         &input.Data,
-		Insecure:     &input.Insecure,
-		PrintBody:    &input.PrintBody,
-		PrintHeaders: &input.PrintHeaders,
-
-		TargetUrl: &input.TargetUrl,
+	execution, err := newProfileRequest(input.ProfileName, input.Overrides, input.RenewToken, keyring, aurlConfigFile)
+	if err != nil {
+		return err
+	}
+	execution.Version = input.Version
+	execution.Method = &input.Method
+	execution.Headers = &input.Headers
+	execution.Data = &input.Data
+	execution.Insecure = &input.Insecure
+	execution.AllowAnyHost = &input.AllowAnyHost
+	execution.PrintBody = &input.PrintBody
+	execution.PrintHeaders = &input.PrintHeaders
+	execution.ShowTiming = &input.Timing
+	execution.WriteOut = &input.WriteOut
+	execution.Pretty = &printPretty
+	execution.Color = &color
+	execution.Filter = filter
+	if input.Paginate || input.Pagination.NextField != "" || input.Pagination.ItemsField != "" || input.Pagination.NDJSON {
+		if input.PrintHeaders {
+			return fmt.Errorf("--print-headers can't be used with --paginate")
+		}
+		execution.Paginate = &input.Pagination
+	}
+	execution.Stream = &input.Stream
+	if input.TargetUrl, err = execution.Config.ResolveURL(input.TargetUrl); err != nil {
+		return err
 	}
+	execution.TargetUrl = &input.TargetUrl
 
-	kingpin.FatalIfError(execution.Execute(keyring), "Request failed")
+	if input.HARFile != "" {
+		request.Archive = request.NewHAR(input.Version)
+	}
+	err = execution.Execute(keyring)
+	// the archive of a failed request is the most useful one
+	if input.HARFile != "" {
+		if harErr := request.Archive.WriteFile(input.HARFile); harErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to write %s: %v\n", input.HARFile, harErr)
+		}
+	}
+	kingpin.FatalIfError(err, "Request failed")
 
 	return nil
 }
@@ -4,14 +4,25 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"os"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/agent"
+	"github.com/classmethod/aurl/redact"
+	"github.com/classmethod/aurl/request"
+	"github.com/classmethod/aurl/telemetry"
 	"github.com/classmethod/aurl/vault"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Aurl struct {
 	Verbose        bool
+	VerboseUnsafe  bool
+	LogFormat      string
+	TraceFile      string
+	TraceASCIIFile string
 	aurlConfigFile *vault.ConfigFile
 	KeyringConfig  keyring.Config
 	keyringImpl    keyring.Keyring
@@ -27,13 +38,43 @@ var keyringConfigDefaults = keyring.Config{
 	KeychainTrustApplication: true,
 }
 
+// Keyring returns the agent advertised by AURL_AGENT_SOCK if it is running, the OS keyring otherwise
 func (a *Aurl) Keyring() (keyring.Keyring, error) {
+	if a.keyringImpl == nil {
+		if socketPath := os.Getenv(agent.SocketEnvVar); socketPath != "" {
+			client := &agent.Client{SocketPath: socketPath}
+			err := client.Ping()
+			if err == nil {
+				slog.Debug("using agent", "socket", socketPath)
+				a.keyringImpl = client
+				return a.tracedKeyring(), nil
+			}
+			slog.Warn("agent not available, falling back to keyring", "socket", socketPath, "error", err)
+		}
+	}
+	if _, err := a.backendKeyring(); err != nil {
+		return nil, err
+	}
+	return a.tracedKeyring(), nil
+}
+
+// tracedKeyring records the keyring operations as spans when tracing is enabled
+func (a *Aurl) tracedKeyring() keyring.Keyring {
+	if !telemetry.Enabled() {
+		return a.keyringImpl
+	}
+	return &telemetry.Keyring{Keyring: a.keyringImpl}
+}
+
+func (a *Aurl) backendKeyring() (keyring.Keyring, error) {
 	if a.keyringImpl == nil {
 		if a.KeyringBackend != "" {
 			a.KeyringConfig.AllowedBackends = []keyring.BackendType{keyring.BackendType(a.KeyringBackend)}
 		}
 		var err error
+		_, span := telemetry.Start(telemetry.Context(), "keyring open", attribute.String("aurl.keyring.backend", a.KeyringBackend))
 		a.keyringImpl, err = keyring.Open(a.KeyringConfig)
+		telemetry.End(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -45,7 +86,12 @@ func (a *Aurl) Keyring() (keyring.Keyring, error) {
 func (a *Aurl) AurlConfigFile() (*vault.ConfigFile, error) {
 	if a.aurlConfigFile == nil {
 		var err error
+		_, span := telemetry.Start(telemetry.Context(), "config load")
 		a.aurlConfigFile, err = vault.LoadConfig()
+		if err == nil {
+			span.SetAttributes(attribute.String("aurl.config.path", a.aurlConfigFile.Path))
+		}
+		telemetry.End(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -76,22 +122,90 @@ func ConfigureGlobals(app *kingpin.Application) *Aurl {
 		Short('v').
 		BoolVar(&a.Verbose)
 
+	app.Flag("verbose-unsafe", "Enable verbose logging without masking credentials and tokens in the request and response dumps.").
+		BoolVar(&a.VerboseUnsafe)
+
+	app.Flag("log-format", "Format of the log written to stderr [text json]. json also logs token and response events without --verbose.").
+		Default("text").
+		EnumVar(&a.LogFormat, "text", "json")
+
+	app.Flag("trace", "Write a trace of the HTTP exchanges with a hex dump of the data to the file, - for stderr.").
+		PlaceHolder("FILE").
+		StringVar(&a.TraceFile)
+
+	app.Flag("trace-ascii", "Write a trace of the HTTP exchanges to the file, - for stderr.").
+		PlaceHolder("FILE").
+		StringVar(&a.TraceASCIIFile)
+
 	app.Flag("backend", fmt.Sprintf("Secret backend to use %v", backendsAvailable)).
 		Default(backendsAvailable[0]).
 		EnumVar(&a.KeyringBackend, backendsAvailable...)
 
+	// flush the spans when a command fails
+	terminate := func(status int) {
+		telemetry.Shutdown(status)
+		os.Exit(status)
+	}
+	app.Terminate(terminate)
+	kingpin.CommandLine.Terminate(terminate)
+
 	app.PreAction(func(c *kingpin.ParseContext) error {
-		if a.Verbose {
+		if a.VerboseUnsafe {
+			a.Verbose = true
+			redact.Disabled = true
+		}
+		if a.LogFormat == "json" {
+			level := slog.LevelInfo
+			if a.Verbose {
+				level = slog.LevelDebug
+			}
+			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+			if !a.Verbose {
+				log.SetOutput(io.Discard)
+			}
+		} else if a.Verbose {
 			log.SetOutput(log.Writer())
 			log.SetPrefix("**** ")
 			log.SetFlags(log.LstdFlags | log.Lshortfile)
+			slog.SetLogLoggerLevel(slog.LevelDebug)
 		} else {
 			log.SetOutput(io.Discard)
 		}
 
-		log.Printf("%s %s", app.Model().Name, app.Model().Version)
+		if err := a.openTrace(); err != nil {
+			return err
+		}
+
+		if c.SelectedCommand != nil {
+			if err := telemetry.Setup(c.SelectedCommand.FullCommand(), app.Model().Version); err != nil {
+				return fmt.Errorf("Error setting up tracing: %w", err)
+			}
+		}
+
+		slog.Debug("version", "name", app.Model().Name, "version", app.Model().Version)
 		return nil
 	})
 
 	return a
 }
+
+// openTrace sets up the trace requested by --trace or --trace-ascii
+func (a *Aurl) openTrace() error {
+	path, hex := a.TraceASCIIFile, false
+	if a.TraceFile != "" {
+		path, hex = a.TraceFile, true
+	}
+	if path == "" {
+		return nil
+	}
+	if path == "-" {
+		request.Trace = &request.Tracer{W: os.Stderr, Hex: hex}
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Error opening trace file: %w", err)
+	}
+	request.Trace = &request.Tracer{W: f, Hex: hex}
+	return nil
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/vault"
+)
+
+type MigrateCommandInput struct {
+	ProfilesFile string
+	DryRun       bool
+	Force        bool
+}
+
+func ConfigureMigrateCommand(app *kingpin.Application, a *Aurl) {
+	input := MigrateCommandInput{}
+
+	cmd := app.Command("migrate", "Migrate profiles from the aurl v1 ~/.aurl/profiles file.")
+
+	cmd.Flag("from", "Path of the v1 profiles file. (default: ~/.aurl/profiles)").
+		StringVar(&input.ProfilesFile)
+	cmd.Flag("dry-run", "Only report what would be migrated.").
+		BoolVar(&input.DryRun)
+	cmd.Flag("force", "Overwrite profiles which already exist in the config file.").
+		BoolVar(&input.Force)
+
+	cmd.Action(func(c *kingpin.ParseContext) error {
+		var keyring keyring.Keyring
+		if !input.DryRun {
+			var err error
+			if keyring, err = a.Keyring(); err != nil {
+				return err
+			}
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(MigrateCommand(input, keyring, aurlConfigFile), "migrate")
+		return nil
+	})
+}
+
+func MigrateCommand(input MigrateCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	path := input.ProfilesFile
+	if path == "" {
+		var err error
+		if path, err = vault.V1ProfilesPath(); err != nil {
+			return err
+		}
+	}
+	profiles, err := vault.LoadV1Profiles(path)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		fmt.Printf("No profiles found in %s\n", path)
+		return nil
+	}
+
+	prefix := ""
+	if input.DryRun {
+		prefix = "(dry run) "
+	}
+	migrated := 0
+	for _, profile := range profiles {
+		if _, hasProfile := aurlConfigFile.ProfileSection(profile.Name); hasProfile && !input.Force {
+			fmt.Printf("%sSkipping profile %q: already exists in config at %s (use --force to override)\n", prefix, profile.Name, aurlConfigFile.Path)
+			continue
+		}
+
+		fmt.Printf("%sProfile %q:\n", prefix, profile.Name)
+		for _, change := range v1Changes(profile) {
+			fmt.Printf("  %s\n", change)
+		}
+		if input.DryRun {
+			continue
+		}
+
+		ckr := &vault.CredentialKeyring{Keyring: keyring}
+		// the credentials replaced with --force are put back if the profile can't be added
+		previous, err := ckr.Get(profile.Name)
+		if err != nil && !errors.Is(err, vault.ErrNotFound) {
+			return fmt.Errorf("Failed to get credentials of profile %q: %w", profile.Name, err)
+		}
+		if err := ckr.Set(profile.Name, profile.Credentials()); err != nil {
+			return fmt.Errorf("Error storing credentials of profile %q in keyring: %w", profile.Name, err)
+		}
+		if err := aurlConfigFile.Add(profile.ProfileSection()); err != nil {
+			if previous != nil {
+				ckr.Set(profile.Name, *previous)
+			} else {
+				ckr.Remove(profile.Name)
+			}
+			return fmt.Errorf("Error adding profile %q: %w", profile.Name, err)
+		}
+		tkr := &vault.TokenKeyring{Keyring: keyring}
+		if err := tkr.RemoveAll(profile.Name); err != nil {
+			fmt.Printf("Warning: Failed to remove existing tokens for profile %q: %v\n", profile.Name, err)
+		}
+		migrated++
+	}
+
+	if input.DryRun {
+		return nil
+	}
+	fmt.Printf("Migrated %d of %d profiles to %s\n", migrated, len(profiles), aurlConfigFile.Path)
+	if migrated > 0 {
+		fmt.Printf("Tokens of aurl v1 are not migrated, you will be asked to sign in again.\n")
+		fmt.Printf("%s still holds secrets in plain text, delete it once you have checked the migrated profiles.\n", path)
+	}
+	return nil
+}
+
+// v1Changes describes how the keys of a v1 profile are carried over
+func v1Changes(profile vault.V1Profile) []string {
+	changes := []string{}
+	section := profile.ProfileSection()
+	for _, field := range vault.ProfileFields() {
+		if field.IsSet(section) {
+			changes = append(changes, fmt.Sprintf("config: %s = %v", field.Key, field.Value(section)))
+		}
+	}
+	if profile.DefaultContentType != "" {
+		changes = append(changes, "default_content_type renamed to content_type")
+	}
+	if profile.DefaultUserAgent != "" {
+		changes = append(changes, "default_user_agent renamed to user_agent")
+	}
+
+	secrets := []string{"client_id", "client_secret"}
+	if profile.Username != "" {
+		secrets = append(secrets, "username")
+	}
+	if profile.Password != "" {
+		secrets = append(secrets, "password")
+	}
+	changes = append(changes, fmt.Sprintf("keyring: %s", strings.Join(secrets, ", ")))
+	return changes
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/vault"
+)
+
+type ProfileCopyCommandInput struct {
+	Source      string
+	Destination string
+	Rename      bool
+}
+
+func ConfigureProfileCommands(app *kingpin.Application, a *Aurl) {
+	for _, rename := range []bool{false, true} {
+		input := ProfileCopyCommandInput{Rename: rename}
+
+		var cmd *kingpin.CmdClause
+		if rename {
+			cmd = app.Command("rename", "Rename a profile, moving its credentials and cached tokens.")
+		} else {
+			cmd = app.Command("copy", "Copy a profile and its credentials to a new profile.")
+		}
+		cmd.Arg("profile", "Name of the existing profile.").
+			Required().
+			HintAction(a.MustGetProfileNames).
+			StringVar(&input.Source)
+		cmd.Arg("new-profile", "Name of the new profile.").
+			Required().
+			StringVar(&input.Destination)
+
+		cmd.Action(func(c *kingpin.ParseContext) error {
+			keyring, err := a.Keyring()
+			if err != nil {
+				return err
+			}
+			aurlConfigFile, err := a.AurlConfigFile()
+			if err != nil {
+				return err
+			}
+
+			kingpin.FatalIfError(ProfileCopyCommand(input, keyring, aurlConfigFile), "%s", cmd.FullCommand())
+			return nil
+		})
+	}
+}
+
+func ProfileCopyCommand(input ProfileCopyCommandInput, kr keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	if input.Source == input.Destination {
+		return fmt.Errorf("Profile %q can't be copied onto itself", input.Source)
+	}
+	ckr := &vault.CredentialKeyring{Keyring: kr}
+	if _, err := ckr.Get(input.Destination); err == nil {
+		return fmt.Errorf("Credentials for profile %q already exist in the keyring", input.Destination)
+	}
+
+	// the config is changed in memory first, which checks both names, and saved once the keyring holds
+	// what the new profile needs, so a failure leaves the profile as it was
+	if input.Rename {
+		if err := aurlConfigFile.RenameProfile(input.Source, input.Destination); err != nil {
+			return err
+		}
+	} else {
+		if err := aurlConfigFile.CopyProfile(input.Source, input.Destination); err != nil {
+			return err
+		}
+	}
+
+	// profiles only providing settings to others may have no credentials
+	creds, err := ckr.Get(input.Source)
+	if err != nil && !errors.Is(err, vault.ErrNotFound) {
+		return fmt.Errorf("Failed to get credentials: %w", err)
+	}
+	if creds != nil {
+		if err := ckr.Set(input.Destination, *creds); err != nil {
+			return fmt.Errorf("Error storing credentials in keyring: %w", err)
+		}
+	}
+	tkr := &vault.TokenKeyring{Keyring: kr}
+	if input.Rename {
+		// cached tokens are requested again if they can't be moved
+		if err := tkr.Move(input.Source, input.Destination); err != nil {
+			fmt.Printf("Warning: Failed to move cached tokens of profile %q: %v\n", input.Source, err)
+		}
+	}
+
+	if err := aurlConfigFile.Save(); err != nil {
+		if creds != nil {
+			ckr.Remove(input.Destination)
+		}
+		if input.Rename {
+			tkr.Move(input.Destination, input.Source)
+		}
+		return fmt.Errorf("Error saving config: %w", err)
+	}
+
+	if !input.Rename {
+		fmt.Printf("Copied profile %q to %q\n", input.Source, input.Destination)
+		return nil
+	}
+	if creds != nil {
+		if err := ckr.Remove(input.Source); err != nil {
+			fmt.Printf("Warning: Failed to remove credentials of profile %q: %v\n", input.Source, err)
+		}
+	}
+	fmt.Printf("Renamed profile %q to %q\n", input.Source, input.Destination)
+	return nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/util"
+	"github.com/classmethod/aurl/vault"
+)
+
+type SecretCommandInput struct {
+	Name  string
+	Stdin bool
+}
+
+func ConfigureSecretCommand(app *kingpin.Application, a *Aurl) {
+	cmd := app.Command("secret", "Manage the secrets referenced as ${keyring:NAME} in header and query keys.")
+
+	setInput := SecretCommandInput{}
+	set := cmd.Command("set", "Store a secret, prompting for its value.")
+	set.Arg("name", "Name of the secret.").
+		Required().
+		StringVar(&setInput.Name)
+	set.Flag("stdin", "Read the value from the first line of stdin.").
+		BoolVar(&setInput.Stdin)
+	set.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(SecretSetCommand(setInput, keyring), "secret set")
+		return nil
+	})
+
+	removeInput := SecretCommandInput{}
+	remove := cmd.Command("remove", "Remove a secret.")
+	remove.Arg("name", "Name of the secret.").
+		Required().
+		StringVar(&removeInput.Name)
+	remove.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(SecretRemoveCommand(removeInput, keyring), "secret remove")
+		return nil
+	})
+
+	list := cmd.Command("list", "List the names of the stored secrets.")
+	list.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		kingpin.FatalIfError(SecretListCommand(keyring), "secret list")
+		return nil
+	})
+}
+
+func SecretSetCommand(input SecretCommandInput, keyring keyring.Keyring) error {
+	var value string
+	var err error
+	if input.Stdin {
+		value, err = readLine(bufio.NewReader(os.Stdin))
+	} else if util.IsTerminal() {
+		value, err = util.TerminalSecretPrompt(fmt.Sprintf("Enter value of secret %s: ", input.Name))
+	} else {
+		return fmt.Errorf("No terminal to prompt for the secret, use --stdin")
+	}
+	if err != nil {
+		return err
+	}
+	if err := (&vault.SecretKeyring{Keyring: keyring}).Set(input.Name, value); err != nil {
+		return fmt.Errorf("Error storing secret in keyring: %w", err)
+	}
+	fmt.Printf("Stored secret %q, reference it as ${keyring:%s}\n", input.Name, input.Name)
+	return nil
+}
+
+func SecretRemoveCommand(input SecretCommandInput, keyring keyring.Keyring) error {
+	if err := (&vault.SecretKeyring{Keyring: keyring}).Remove(input.Name); err != nil {
+		return fmt.Errorf("Error removing secret %q: %w", input.Name, err)
+	}
+	fmt.Printf("Removed secret %q\n", input.Name)
+	return nil
+}
+
+func SecretListCommand(keyring keyring.Keyring) error {
+	names, err := (&vault.SecretKeyring{Keyring: keyring}).Names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/request"
+	"github.com/classmethod/aurl/telemetry"
+	"github.com/classmethod/aurl/vault"
+)
+
+// TokenOverrides replace the scope and audience configured in the profile for a single invocation
+type TokenOverrides struct {
+	Scope     string
+	Audience  string
+	Resources []string
+}
+
+func configureTokenOverrides(cmd *kingpin.CmdClause, overrides *TokenOverrides) {
+	cmd.Flag("scope", "Request these scopes (space separated) instead of the ones configured in the profile.").
+		StringVar(&overrides.Scope)
+	cmd.Flag("audience", "Request a token for this audience.").
+		StringVar(&overrides.Audience)
+	cmd.Flag("resource", "Request a token for this resource indicator (RFC 8707). Can be repeated.").
+		StringsVar(&overrides.Resources)
+}
+
+func (o TokenOverrides) apply(config *vault.Config) {
+	if o.Scope != "" {
+		config.Scope = o.Scope
+	}
+	if o.Audience != "" {
+		config.Audience = o.Audience
+	}
+	if len(o.Resources) > 0 {
+		config.Resources = o.Resources
+	}
+}
+
+type TokenCommandInput struct {
+	ProfileName string
+	RenewToken  bool
+	Insecure    bool
+	Overrides   TokenOverrides
+}
+
+func ConfigureTokenCommand(app *kingpin.Application, a *Aurl) {
+	input := TokenCommandInput{}
+
+	cmd := app.Command("token", "Print an access token for the profile.")
+
+	cmd.Arg("profile", "Name of the profile to use. (default: $"+vault.ProfileEnvVar+")").
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+	cmd.Flag("renew-token", "Force renewal of access token even if a valid token exists in the keyring.").
+		BoolVar(&input.RenewToken)
+	cmd.Flag("insecure", "Disable SSL certificate verification.").
+		Short('k').
+		BoolVar(&input.Insecure)
+	configureTokenOverrides(cmd, &input.Overrides)
+
+	cmd.Action(func(c *kingpin.ParseContext) error {
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+
+		kingpin.FatalIfError(TokenCommand(input, keyring, aurlConfigFile), "token")
+		return nil
+	})
+}
+
+func TokenCommand(input TokenCommandInput, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	profileName, err := profileOrDefault(input.ProfileName)
+	if err != nil {
+		return err
+	}
+	execution, err := newProfileRequest(profileName, input.Overrides, input.RenewToken, keyring, aurlConfigFile)
+	if err != nil {
+		return err
+	}
+	execution.Insecure = &input.Insecure
+
+	if err := execution.AcquireToken(telemetry.Context(), keyring); err != nil {
+		return err
+	}
+	fmt.Println(execution.TokenInfo.Tokens.AccessToken)
+	return nil
+}
+
+// profileOrDefault returns the profile name, or the one named by AURL_PROFILE if it is empty
+func profileOrDefault(profileName string) (string, error) {
+	if profileName == "" {
+		profileName = vault.DefaultProfile()
+	}
+	if profileName == "" {
+		return "", fmt.Errorf("No profile given and %s is not set", vault.ProfileEnvVar)
+	}
+	return profileName, nil
+}
+
+// targetOrProfile tells whether the only positional argument of a command taking an optional profile
+// followed by a target is the target, in which case the profile comes from AURL_PROFILE. isTarget
+// judges the argument by its shape. An argument which is also the name of a profile is ambiguous.
+func targetOrProfile(arg, targetName string, isTarget bool, aurlConfigFile *vault.ConfigFile) (bool, error) {
+	_, isProfile := aurlConfigFile.ProfileSection(arg)
+	if isTarget && isProfile {
+		return false, fmt.Errorf("%q is both a profile and a %s, give the profile and the %s", arg, targetName, targetName)
+	}
+	return isTarget, nil
+}
+
+// newProfileRequest loads the profile config, credentials and cached token needed to obtain an access token
+func newProfileRequest(profileName string, overrides TokenOverrides, renewToken bool, keyring keyring.Keyring, aurlConfigFile *vault.ConfigFile) (*request.Request, error) {
+	config, err := vault.NewConfigLoader(aurlConfigFile, profileName).GetProfileConfig(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %w", err)
+	}
+	overrides.apply(config)
+
+	ckr := &vault.CredentialKeyring{Keyring: keyring}
+	creds, credsErr := ckr.Get(profileName)
+	if credsErr != nil {
+		return nil, fmt.Errorf("Failed to get credentials: %w", credsErr)
+	}
+	if missing := vault.MissingCredentials(config.GrantType, creds); len(missing) > 0 {
+		return nil, fmt.Errorf("Profile %q has no %s in the keyring, set with: aurl credentials set %s", profileName, strings.Join(missing, ", "), profileName)
+	}
+	if err := config.ResolveSecrets(&vault.SecretKeyring{Keyring: keyring}); err != nil {
+		return nil, err
+	}
+
+	var tokenInfo *vault.TokenInfo
+	// Load previous token from keyring unless RenewToken is specified
+	if !renewToken {
+		tkr := &vault.TokenKeyring{Keyring: keyring}
+		if tokenInfo, err = tkr.Get(config.TokenCacheKey()); err != nil {
+			slog.Debug("previous token not found in keyring", "error", err)
+		} else if tokenInfo.Tokens == nil {
+			// an item without tokens, corrupt or written by an older aurl, is a cache miss
+			slog.Debug("cached token is empty, ignoring it")
+			tokenInfo = nil
+		} else if missing := vault.MissingScopes(config.Scopes(), tokenInfo.Tokens.Scope); len(missing) > 0 {
+			slog.Debug("cached token does not cover the scopes, ignoring it", "missing", missing)
+			tokenInfo = nil
+		}
+	}
+
+	return &request.Request{
+		Name: profileName,
+
+		Config:      config,
+		Credentials: creds,
+		TokenInfo:   tokenInfo,
+		TokenLock:   vault.NewTokenLock(aurlConfigFile, profileName),
+		RenewToken:  renewToken,
+	}, nil
+}
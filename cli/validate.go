@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/vault"
+)
+
+type ConfigValidateCommandInput struct {
+	ProfileName string
+}
+
+func configureConfigValidateCommand(cmd *kingpin.CmdClause, a *Aurl) {
+	input := ConfigValidateCommandInput{}
+	validate := cmd.Command("validate", "Check a profile, or every profile, for missing or invalid settings and credentials.")
+	validate.Arg("profile", "Name of the profile to check. (default: all profiles)").
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+	validate.Action(func(c *kingpin.ParseContext) error {
+		aurlConfigFile, err := a.AurlConfigFile()
+		if err != nil {
+			return err
+		}
+		keyring, err := a.Keyring()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Credentials not checked, keyring not available: %v\n", err)
+			keyring = nil
+		}
+		kingpin.FatalIfError(ConfigValidateCommand(input, keyring, aurlConfigFile), "config validate")
+		return nil
+	})
+}
+
+func ConfigValidateCommand(input ConfigValidateCommandInput, kr keyring.Keyring, aurlConfigFile *vault.ConfigFile) error {
+	profileNames := []string{input.ProfileName}
+	if input.ProfileName == "" {
+		profileNames = aurlConfigFile.ProfileNames()
+	}
+
+	problems := []vault.KeyProblem{}
+	for _, name := range profileNames {
+		// profiles extended by others, and [default], may only hold shared settings
+		base := input.ProfileName == "" && (name == "default" || len(aurlConfigFile.Dependents(name)) > 1)
+		loader := vault.NewConfigLoader(aurlConfigFile, name)
+		profileProblems := append(loader.UnknownKeys(name), loader.ValidateProfile(name, base)...)
+		if kr != nil && !base {
+			profileProblems = append(profileProblems, credentialProblems(loader, name, kr)...)
+		}
+		problems = append(problems, profileProblems...)
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d profile(s) valid\n", aurlConfigFile.Path, len(profileNames))
+	return nil
+}
+
+// credentialProblems checks the keyring holds the credentials required by the profile's grant type
+// and the secrets referenced by its headers and query parameters
+func credentialProblems(loader *vault.ConfigLoader, profileName string, kr keyring.Keyring) []vault.KeyProblem {
+	profile, _, err := loader.EffectiveProfile(profileName)
+	if err != nil {
+		// already reported by ValidateProfile
+		return nil
+	}
+	problems := []vault.KeyProblem{}
+	skr := &vault.SecretKeyring{Keyring: kr}
+	for _, name := range vault.SecretReferences(append(append([]string{}, profile.Headers...), profile.Query...)) {
+		if _, err := skr.Get(name); err != nil {
+			problems = append(problems, vault.KeyProblem{Path: "keyring", Profile: profileName,
+				Message: fmt.Sprintf("secret %q not found, set it with: aurl secret set %s", name, name)})
+		}
+	}
+
+	problem := vault.KeyProblem{Path: "keyring", Profile: profileName}
+	creds, err := (&vault.CredentialKeyring{Keyring: kr}).Get(profileName)
+	switch {
+	case errors.Is(err, vault.ErrNotFound):
+		problem.Message = fmt.Sprintf("no credentials, set them with: aurl credentials set %s", profileName)
+	case err != nil:
+		problem.Message = err.Error()
+	default:
+		missing := vault.MissingCredentials(profile.GrantType, creds)
+		if len(missing) == 0 {
+			return problems
+		}
+		problem.Message = fmt.Sprintf("%s required by the grant type, set with: aurl credentials set %s", strings.Join(missing, ", "), profileName)
+	}
+	return append(problems, problem)
+}
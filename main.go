@@ -6,6 +6,7 @@ import (
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/classmethod/aurl/cli"
+	"github.com/classmethod/aurl/telemetry"
 )
 
 var (
@@ -22,6 +23,17 @@ func main() {
 	a := cli.ConfigureGlobals(app)
 	cli.ConfigureAddCommand(app, a)
 	cli.ConfigureExecCommand(app, a)
+	cli.ConfigureBatchCommand(app, a)
+	cli.ConfigureTokenCommand(app, a)
+	cli.ConfigureAgentCommand(app, a)
+	cli.ConfigureConfigCommand(app, a)
+	cli.ConfigureEditCommand(app, a)
+	cli.ConfigureCredentialsCommand(app, a)
+	cli.ConfigureSecretCommand(app, a)
+	cli.ConfigureMigrateCommand(app, a)
+	cli.ConfigureProfileCommands(app, a)
+	cli.ConfigureBundleCommands(app, a)
 
 	kingpin.MustParse(app.Parse(os.Args[1:]))
+	telemetry.Shutdown(0)
 }
@@ -0,0 +1,100 @@
+package pretty
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// Filter is a compiled jq expression
+type Filter struct {
+	code *gojq.Code
+}
+
+// CompileFilter parses and compiles a jq expression
+func CompileFilter(expr string) (*Filter, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid jq expression: %w", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid jq expression: %w", err)
+	}
+	return &Filter{code: code}, nil
+}
+
+// Run applies the filter to a JSON document and returns its results as JSON, one per line,
+// indented and coloured as JSON does
+func (f *Filter) Run(data []byte, indent, color bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var input interface{}
+	if err := decoder.Decode(&input); err != nil {
+		return nil, fmt.Errorf("Response is not JSON: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Response is not JSON: invalid data after the top-level value")
+	}
+	var b bytes.Buffer
+	iter := f.code.Run(normalizeNumbers(input))
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
+				break
+			}
+			return nil, fmt.Errorf("jq: %w", err)
+		}
+		result, err := gojq.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if indent {
+			if result, err = JSON(result, color); err != nil {
+				return nil, err
+			}
+			b.Write(result)
+			continue
+		}
+		b.Write(result)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// normalizeNumbers converts the json.Number values of v to the numbers of gojq: int, or *big.Int for
+// integers too large for it, so IDs such as 2^53+1 are kept exact, and float64 for the others
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil {
+			return i
+		}
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+				return i
+			}
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeNumbers(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+package pretty
+
+import "testing"
+
+func TestFilterNumbers(t *testing.T) {
+	tests := []struct {
+		expr  string
+		input string
+		want  string
+	}{
+		{".id", `{"id": 9007199254740993}`, "9007199254740993\n"},
+		{".id", `{"id": 123456789012345678901234567890}`, "123456789012345678901234567890\n"},
+		{".id + 1", `{"id": 9007199254740993}`, "9007199254740994\n"},
+		{".items[].n", `{"items": [{"n": 1}, {"n": -2}]}`, "1\n-2\n"},
+		{".price", `{"price": 1.50}`, "1.5\n"},
+		{".big", `{"big": 1e3}`, "1000\n"},
+		{".", `[1, 2.5, "3"]`, "[1,2.5,\"3\"]\n"},
+	}
+	for _, tt := range tests {
+		filter, err := CompileFilter(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := filter.Run([]byte(tt.input), false, false)
+		if err != nil {
+			t.Errorf("Run(%q, %s): %v", tt.expr, tt.input, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Run(%q, %s) = %q, want %q", tt.expr, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFilterInvalidJSON(t *testing.T) {
+	filter, err := CompileFilter(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{`{"a": 1`, `{"a": 1}}`, `not json`} {
+		if _, err := filter.Run([]byte(input), false, false); err == nil {
+			t.Errorf("Run(%s) succeeds", input)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package pretty indents and colours JSON and XML responses for the terminal
+package pretty
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"strings"
+)
+
+// ANSI colours, close to those of jq
+const (
+	colorReset  = "\x1b[0m"
+	colorKey    = "\x1b[34;1m"
+	colorString = "\x1b[32m"
+	colorNumber = "\x1b[36m"
+	colorBool   = "\x1b[33m"
+	colorNull   = "\x1b[90m"
+	colorTag    = "\x1b[34;1m"
+	colorName   = "\x1b[36m"
+)
+
+// IsJSON reports whether the content type is JSON, including types such as application/problem+json
+func IsJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// IsXML reports whether the content type is XML
+func IsXML(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// JSON indents a JSON document, keeping the order of its keys, and colours it if color is set
+func JSON(data []byte, color bool) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, bytes.TrimSpace(data), "", "  "); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	if !color {
+		return b.Bytes(), nil
+	}
+	return colorJSON(b.Bytes()), nil
+}
+
+// colorJSON colours valid JSON text
+func colorJSON(data []byte) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+			color := colorString
+			if isKey(data[end:]) {
+				color = colorKey
+			}
+			b.WriteString(color)
+			b.Write(data[i:end])
+			b.WriteString(colorReset)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(data) && strings.IndexByte("+-.eE0123456789", data[end]) >= 0 {
+				end++
+			}
+			b.WriteString(colorNumber)
+			b.Write(data[i:end])
+			b.WriteString(colorReset)
+			i = end
+		case bytes.HasPrefix(data[i:], []byte("true")), bytes.HasPrefix(data[i:], []byte("false")):
+			end := i + 4
+			if c == 'f' {
+				end++
+			}
+			b.WriteString(colorBool)
+			b.Write(data[i:end])
+			b.WriteString(colorReset)
+			i = end
+		case bytes.HasPrefix(data[i:], []byte("null")):
+			b.WriteString(colorNull + "null" + colorReset)
+			i += 4
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.Bytes()
+}
+
+// isKey reports whether the string followed by rest is an object key
+func isKey(rest []byte) bool {
+	rest = bytes.TrimLeft(rest, " \t\r\n")
+	return len(rest) > 0 && rest[0] == ':'
+}
+
+// XML indents an XML document and colours its tags if color is set
+func XML(data []byte, color bool) ([]byte, error) {
+	w := &xmlWriter{color: color}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		w.write(token)
+	}
+	w.flushText()
+	return w.b.Bytes(), nil
+}
+
+type xmlWriter struct {
+	b     bytes.Buffer
+	color bool
+	depth int
+	// open is set while the last start tag may still be closed on the same line
+	open bool
+	text string
+}
+
+func (w *xmlWriter) colored(color, s string) {
+	if w.color {
+		w.b.WriteString(color + s + colorReset)
+	} else {
+		w.b.WriteString(s)
+	}
+}
+
+func (w *xmlWriter) indent() {
+	w.b.WriteString(strings.Repeat("  ", w.depth))
+}
+
+// flushText writes the text of an element which turned out to have children
+func (w *xmlWriter) flushText() {
+	if w.open {
+		w.b.WriteByte('\n')
+		w.open = false
+	}
+	if w.text != "" {
+		w.indent()
+		xml.EscapeText(&w.b, []byte(w.text))
+		w.b.WriteByte('\n')
+		w.text = ""
+	}
+}
+
+func (w *xmlWriter) write(token xml.Token) {
+	switch t := token.(type) {
+	case xml.StartElement:
+		w.flushText()
+		w.indent()
+		w.colored(colorTag, "<"+xmlName(t.Name))
+		for _, attr := range t.Attr {
+			var value bytes.Buffer
+			xml.EscapeText(&value, []byte(attr.Value))
+			w.colored(colorName, " "+xmlName(attr.Name)+"=")
+			w.colored(colorString, `"`+value.String()+`"`)
+		}
+		w.colored(colorTag, ">")
+		w.depth++
+		w.open = true
+	case xml.EndElement:
+		if w.open {
+			// an element with text only stays on one line
+			xml.EscapeText(&w.b, []byte(w.text))
+			w.text = ""
+			w.open = false
+			w.depth--
+		} else {
+			w.flushText()
+			w.depth--
+			w.indent()
+		}
+		w.colored(colorTag, "</"+xmlName(t.Name)+">")
+		w.b.WriteByte('\n')
+	case xml.CharData:
+		if text := strings.TrimSpace(string(t)); text != "" {
+			w.text += text
+		}
+	case xml.Comment:
+		w.flushText()
+		w.indent()
+		w.colored(colorNull, "<!--"+string(t)+"-->")
+		w.b.WriteByte('\n')
+	case xml.ProcInst:
+		w.flushText()
+		w.indent()
+		w.colored(colorTag, "<?"+t.Target+" "+string(t.Inst)+"?>")
+		w.b.WriteByte('\n')
+	case xml.Directive:
+		w.flushText()
+		w.indent()
+		w.colored(colorTag, "<!"+string(t)+">")
+		w.b.WriteByte('\n')
+	}
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
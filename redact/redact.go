@@ -0,0 +1,170 @@
+// Package redact masks credentials and tokens in the HTTP dumps written to the verbose log
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secret values
+const Mask = "****"
+
+// Disabled turns off redaction, for --verbose-unsafe
+var Disabled bool
+
+// sensitiveHeaders are masked entirely, except for the authentication scheme
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+// sensitiveParams are masked in form bodies, query strings and JSON bodies
+var sensitiveParams = map[string]bool{
+	"client_secret": true,
+	"password":      true,
+	"refresh_token": true,
+	"access_token":  true,
+	"id_token":      true,
+	"code":          true,
+}
+
+var jsonParamPattern = regexp.MustCompile(`"(client_secret|password|refresh_token|access_token|id_token|code)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+)
+
+// AddSecret registers a value to be masked wherever it appears, such as a secret read from the keyring
+func AddSecret(secret string) {
+	if len(secret) < 4 {
+		// too short to mask without mangling unrelated text
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// String masks the registered secrets in s
+func String(s string) string {
+	if Disabled {
+		return s
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// Header returns the value of the header with secrets masked
+func Header(name, value string) string {
+	if Disabled {
+		return value
+	}
+	if sensitiveHeaders[strings.ToLower(name)] {
+		if scheme, _, ok := strings.Cut(value, " "); ok && !strings.Contains(scheme, "=") {
+			return scheme + " " + Mask
+		}
+		return Mask
+	}
+	if strings.EqualFold(name, "Location") {
+		return URL(value)
+	}
+	return String(value)
+}
+
+// Query masks the sensitive parameters of a query string or form body, keeping the parameter order
+func Query(query string) string {
+	if Disabled {
+		return query
+	}
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		name, _, ok := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && ok && sensitiveParams[unescaped] {
+			pairs[i] = name + "=" + Mask
+		}
+	}
+	return String(strings.Join(pairs, "&"))
+}
+
+// URL masks the sensitive query and fragment parameters of a URL, such as the code of an authorization response
+func URL(u string) string {
+	if Disabled {
+		return u
+	}
+	rest, fragment, hasFragment := strings.Cut(u, "#")
+	base, query, hasQuery := strings.Cut(rest, "?")
+	if hasQuery {
+		base += "?" + Query(query)
+	}
+	if hasFragment {
+		base += "#" + Query(fragment)
+	}
+	return String(base)
+}
+
+// JSON masks the values of sensitive keys anywhere in a JSON document, keeping its formatting
+func JSON(body string) string {
+	if Disabled {
+		return body
+	}
+	return String(jsonParamPattern.ReplaceAllString(body, `"$1"$2"`+Mask+`"`))
+}
+
+// Dump masks secrets in a request or response dump of net/http/httputil
+func Dump(dump []byte) string {
+	if Disabled {
+		return string(dump)
+	}
+	head, body, hasBody := strings.Cut(string(dump), "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	contentType := ""
+	for i, line := range lines {
+		if i == 0 {
+			// request line, or status line of a response
+			if method, rest, ok := strings.Cut(line, " "); ok && !strings.HasPrefix(method, "HTTP/") {
+				target, proto, _ := strings.Cut(rest, " ")
+				lines[i] = method + " " + URL(target) + " " + proto
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = strings.ToLower(value)
+		}
+		lines[i] = name + ": " + Header(name, strings.TrimSpace(value))
+	}
+	result := strings.Join(lines, "\r\n")
+	if !hasBody {
+		return result
+	}
+
+	return result + "\r\n\r\n" + Body(contentType, body)
+}
+
+// Body masks secrets in a request or response body of the content type
+func Body(contentType, body string) string {
+	if Disabled {
+		return body
+	}
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+		return Query(body)
+	case strings.Contains(contentType, "json"):
+		return JSON(body)
+	default:
+		return String(body)
+	}
+}
@@ -0,0 +1,277 @@
+package request
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/pretty"
+	"github.com/classmethod/aurl/telemetry"
+)
+
+// BatchRequest is a line of the input of a batch
+type BatchRequest struct {
+	// ID is copied to the result, the line number is used if it is missing
+	ID      interface{}       `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Body is sent as is if it is a string, as JSON otherwise
+	Body json.RawMessage `json:"body"`
+}
+
+// BatchResult is a line of the output of a batch
+type BatchResult struct {
+	Line    int                 `json:"line"`
+	ID      interface{}         `json:"id"`
+	Method  string              `json:"method,omitempty"`
+	URL     string              `json:"url,omitempty"`
+	Status  int                 `json:"status,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	// Body is embedded as JSON for JSON responses, as a string otherwise
+	Body   interface{}  `json:"body,omitempty"`
+	Timing *BatchTiming `json:"timing,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// BatchTiming is the Timing of a request in milliseconds
+type BatchTiming struct {
+	DNS       float64 `json:"dns_ms"`
+	Connect   float64 `json:"connect_ms"`
+	TLS       float64 `json:"tls_ms"`
+	FirstByte float64 `json:"first_byte_ms"`
+	Total     float64 `json:"total_ms"`
+}
+
+// Rate is a number of requests per interval
+type Rate struct {
+	Count    int
+	Interval time.Duration
+}
+
+// ParseRate parses a rate such as 10/s, 100/m or 1000/h. A number alone is per second.
+func ParseRate(s string) (Rate, error) {
+	count, unit, hasUnit := strings.Cut(s, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("Invalid rate %q, use a positive number of requests such as 10/s", s)
+	}
+	intervals := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	interval := time.Second
+	if hasUnit {
+		var ok bool
+		if interval, ok = intervals[strings.TrimSpace(unit)]; !ok {
+			return Rate{}, fmt.Errorf("Invalid rate %q, the unit must be s, m or h", s)
+		}
+	}
+	if interval/time.Duration(n) == 0 {
+		return Rate{}, fmt.Errorf("Invalid rate %q, at most %d/s", s, time.Second)
+	}
+	return Rate{Count: n, Interval: interval}, nil
+}
+
+type batchLine struct {
+	number int
+	text   string
+}
+
+// ExecuteBatch runs the requests read as JSON lines from input with parallel workers, sharing the
+// HTTP connections and the access token, and writes a result line for each to output in the order
+// they complete. Only requests which could not be sent count as failures, not HTTP error statuses.
+func (r *Request) ExecuteBatch(keyring keyring.Keyring, input io.Reader, output io.Writer, parallel int, rate *Rate) error {
+	if err := r.AcquireToken(telemetry.Context(), keyring); err != nil {
+		return err
+	}
+	r.transport = newTransport(*r.Insecure)
+
+	var limiter <-chan time.Time
+	if rate != nil {
+		ticker := time.NewTicker(rate.Interval / time.Duration(rate.Count))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	b := &batch{base: r, keyring: keyring, output: json.NewEncoder(output)}
+	b.output.SetEscapeHTML(false)
+	lines := make(chan batchLine)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				if limiter != nil {
+					<-limiter
+				}
+				b.write(b.run(line))
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			lines <- batchLine{number: number, text: text}
+		}
+	}
+	close(lines)
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading requests: %w", err)
+	}
+	if b.failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", b.failed, b.total)
+	}
+	return nil
+}
+
+type batch struct {
+	base    *Request
+	keyring keyring.Keyring
+
+	// tokenMu guards the token of base, shared by the workers
+	tokenMu sync.Mutex
+
+	outputMu sync.Mutex
+	output   *json.Encoder
+	total    int
+	failed   int
+}
+
+func (b *batch) write(result *BatchResult) {
+	b.outputMu.Lock()
+	defer b.outputMu.Unlock()
+	b.total++
+	if result.Error != "" {
+		b.failed++
+	}
+	if err := b.output.Encode(result); err != nil {
+		slog.Warn("failed to write batch result", "line", result.Line, "error", err)
+	}
+}
+
+// token returns a valid token, renewing it if it is about to expire or was rejected.
+// A token rejected by several workers at once is only renewed once.
+func (b *batch) token(rejected string) (*Request, error) {
+	b.tokenMu.Lock()
+	defer b.tokenMu.Unlock()
+	r := b.base
+	var err error
+	if rejected != "" && r.TokenInfo.Tokens.AccessToken == rejected {
+		err = r.renewRejectedToken(b.keyring)
+	} else {
+		err = r.AcquireToken(telemetry.Context(), b.keyring)
+	}
+	if err != nil {
+		return nil, err
+	}
+	clone := *r
+	tokenInfo := *r.TokenInfo
+	tokens := *r.TokenInfo.Tokens
+	tokenInfo.Tokens = &tokens
+	clone.TokenInfo = &tokenInfo
+	return &clone, nil
+}
+
+// run sends the request of a line, renewing the token once if it is rejected
+func (b *batch) run(line batchLine) *BatchResult {
+	result := &BatchResult{Line: line.number, ID: line.number}
+	var req BatchRequest
+	if err := json.Unmarshal([]byte(line.text), &req); err != nil {
+		result.Error = fmt.Sprintf("Invalid request: %v", err)
+		return result
+	}
+	if req.ID != nil {
+		result.ID = req.ID
+	}
+	if req.Method == "" {
+		req.Method = "GET"
+	}
+	result.Method = req.Method
+	if req.URL == "" {
+		result.Error = "Invalid request: url is missing"
+		return result
+	}
+	target, err := b.base.Config.ResolveURL(req.URL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.URL = target
+	data := ""
+	if len(req.Body) > 0 && string(req.Body) != "null" {
+		if err := json.Unmarshal(req.Body, &data); err != nil {
+			data = string(req.Body)
+		}
+	}
+	headers := []string{}
+	for name, value := range req.Headers {
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+
+	var response *http.Response
+	rejected := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		r, err := b.token(rejected)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		r.Method = &req.Method
+		r.TargetUrl = &target
+		r.Data = &data
+		r.Headers = &headers
+		response, err = r.doRequest()
+		if response != nil && response.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			rejected = r.TokenInfo.Tokens.AccessToken
+			response.Body.Close()
+			continue
+		}
+		if response == nil {
+			result.Error = err.Error()
+			return result
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		r.Timing.done()
+		if err != nil {
+			result.Error = fmt.Sprintf("Error reading response: %v", err)
+		}
+		result.Status = response.StatusCode
+		result.Headers = response.Header
+		result.Body = batchBody(response.Header.Get("Content-Type"), body)
+		result.Timing = &BatchTiming{
+			DNS:       durationMs(r.Timing.DNS),
+			Connect:   durationMs(r.Timing.Connect),
+			TLS:       durationMs(r.Timing.TLS),
+			FirstByte: durationMs(r.Timing.FirstByte),
+			Total:     durationMs(r.Timing.Total),
+		}
+		break
+	}
+	return result
+}
+
+// batchBody embeds JSON responses in the result as they are, other responses as strings
+func batchBody(contentType string, body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if pretty.IsJSON(contentType) && json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
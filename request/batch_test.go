@@ -0,0 +1,32 @@
+package request
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want Rate
+		ok   bool
+	}{
+		{"10/s", Rate{Count: 10, Interval: time.Second}, true},
+		{"100/m", Rate{Count: 100, Interval: time.Minute}, true},
+		{" 5 / h ", Rate{Count: 5, Interval: time.Hour}, true},
+		{"3", Rate{Count: 3, Interval: time.Second}, true},
+		{"1000000000/s", Rate{Count: 1000000000, Interval: time.Second}, true},
+		{"1000000001/s", Rate{}, false},
+		{"9223372036854775807/h", Rate{}, false},
+		{"0/s", Rate{}, false},
+		{"-1/s", Rate{}, false},
+		{"10/d", Rate{}, false},
+		{"ten", Rate{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.rate)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v, want %v, ok %v", tt.rate, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package request
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/classmethod/aurl/redact"
+)
+
+// HAR records the HTTP exchanges of a session as an HTTP Archive 1.2, with secrets masked
+type HAR struct {
+	mu      sync.Mutex
+	creator harCreator
+	entries []harEntry
+}
+
+// Archive is set by --har to record the token and resource requests
+var Archive *HAR
+
+func NewHAR(version string) *HAR {
+	return &HAR{creator: harCreator{Name: "aurl", Version: version}}
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+// harTimings are in milliseconds, -1 for phases which didn't happen such as DNS on a reused connection
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteFile writes the archive to the file, readable by the browser devtools
+func (h *HAR) WriteFile(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.entries
+	if entries == nil {
+		entries = []harEntry{}
+	}
+	archive := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": h.creator,
+			"pages":   []interface{}{},
+			"entries": entries,
+		},
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0600)
+}
+
+type harTransport struct {
+	next http.RoundTripper
+	har  *HAR
+}
+
+// phases collects the times of the httptrace events of a single round trip
+type phases struct {
+	mu                                                       sync.Mutex
+	dnsStart, dnsDone, connectStart, connectDone             time.Time
+	tlsStart, tlsDone, gotConn, wroteRequest, firstByte, end time.Time
+}
+
+func (p *phases) set(t *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := &phases{}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.set(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.set(&p.dnsDone) },
+		ConnectStart:         func(string, string) { p.set(&p.connectStart) },
+		ConnectDone:          func(string, string, error) { p.set(&p.connectDone) },
+		TLSHandshakeStart:    func() { p.set(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.set(&p.tlsDone) },
+		GotConn:              func(httptrace.GotConnInfo) { p.set(&p.gotConn) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.set(&p.wroteRequest) },
+		GotFirstResponseByte: func() { p.set(&p.firstByte) },
+	}
+	start := time.Now()
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Request:         harRequestOf(req),
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		entry.Comment = err.Error()
+		entry.Response = harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}}
+	} else {
+		entry.Response = harResponseOf(resp)
+	}
+	p.set(&p.end)
+	entry.Timings = p.timings(start)
+	for _, d := range []float64{entry.Timings.Blocked, entry.Timings.DNS, entry.Timings.Connect, entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive} {
+		if d > 0 {
+			entry.Time += d
+		}
+	}
+	entry.Time = math.Round(entry.Time*1000) / 1000
+
+	t.har.mu.Lock()
+	t.har.entries = append(t.har.entries, entry)
+	t.har.mu.Unlock()
+	return resp, err
+}
+
+// timings converts the phases to HAR timings. connect includes ssl, as HAR specifies.
+func (p *phases) timings(start time.Time) harTimings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	between := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() {
+			return -1
+		}
+		return durationMs(to.Sub(from))
+	}
+	t := harTimings{
+		DNS:     between(p.dnsStart, p.dnsDone),
+		Connect: between(p.connectStart, p.tlsDone),
+		SSL:     between(p.tlsStart, p.tlsDone),
+		Send:    between(p.gotConn, p.wroteRequest),
+		Wait:    between(p.wroteRequest, p.firstByte),
+		Receive: between(p.firstByte, p.end),
+	}
+	if p.tlsDone.IsZero() {
+		t.Connect = between(p.connectStart, p.connectDone)
+	}
+	// time spent before the connection was ready, other than DNS and connect
+	t.Blocked = between(start, p.gotConn)
+	for _, d := range []float64{t.DNS, t.Connect} {
+		if d > 0 && t.Blocked > 0 {
+			t.Blocked -= d
+		}
+	}
+	if t.Blocked < 0 {
+		t.Blocked = -1
+	} else {
+		t.Blocked = math.Round(t.Blocked*1000) / 1000
+	}
+	for _, d := range []*float64{&t.Send, &t.Wait, &t.Receive} {
+		if *d < 0 {
+			*d = 0
+		}
+	}
+	return t
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{Name: name, Value: redact.Header(name, value)})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+func harRequestOf(req *http.Request) harRequest {
+	u := redact.URL(req.URL.String())
+	r := harRequest{
+		Method:      req.Method,
+		URL:         u,
+		HTTPVersion: req.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	if parsed, err := url.Parse(u); err == nil {
+		for name, values := range parsed.Query() {
+			for _, value := range values {
+				r.QueryString = append(r.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+		sort.SliceStable(r.QueryString, func(i, j int) bool { return r.QueryString[i].Name < r.QueryString[j].Name })
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			if len(data) > 0 {
+				contentType := req.Header.Get("Content-Type")
+				r.PostData = &harPostData{MimeType: contentType, Text: redact.Body(contentType, string(data))}
+				r.BodySize = len(data)
+			}
+		}
+	}
+	return r
+}
+
+// harResponseOf records the response, reading the body ahead unless it is a stream
+func harResponseOf(resp *http.Response) harResponse {
+	contentType := resp.Header.Get("Content-Type")
+	r := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{Size: -1, MimeType: contentType},
+		RedirectURL: redact.URL(resp.Header.Get("Location")),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if isStreaming(resp) {
+		return r
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return r
+	}
+	r.Content.Size = len(data)
+	r.Content.Text = redact.Body(contentType, string(data))
+	r.BodySize = len(data)
+	return r
+}
@@ -2,45 +2,70 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/classmethod/aurl/redact"
+	"github.com/classmethod/aurl/telemetry"
 	"github.com/classmethod/aurl/util"
 	"github.com/classmethod/aurl/vault"
 	"github.com/toqueteos/webbrowser"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // OAuth2TokenResponse represents the token response from OAuth2 authorization server
 type OAuth2TokenResponse struct {
-	AccessToken  string
-	RefreshToken string
-	TokenType    string
-	ExpiresIn    *int64
-	Scope        string
-	IdToken      string
+	AccessToken      string
+	RefreshToken     string
+	TokenType        string
+	ExpiresIn        *int64
+	RefreshExpiresIn *int64
+	Scope            string
+	IdToken          string
+	// Timing of the token request
+	Timing *Timing
 }
 
-func authCodeGrant(config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
+// OAuth2Error represents the error response from OAuth2 authorization server (RFC 6749 section 5.2)
+type OAuth2Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	ErrorURI    string `json:"error_uri"`
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("token request failed with status: %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("token request failed with status: %d (%s)", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("token request failed with status: %d (%s: %s)", e.StatusCode, e.Code, e.Description)
+}
+
+func authCodeGrant(ctx context.Context, config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
 	state, err := random()
 	if err != nil {
 		return nil, err
 	}
-	authZRequestUrl := authorizationRequestURL("code", config.AuthorizationEndpoint, credentials.ClientId, config.RedirectURI, config.Scope, state)
+	authZRequestUrl := authorizationRequestURL("code", config, credentials.ClientId, state)
 
 	fmt.Fprintf(os.Stderr, "Open browser and get code from %s\n", authZRequestUrl)
 	if err := webbrowser.Open(authZRequestUrl); err != nil {
-		log.Printf("Failed to open browser: %v", err)
+		slog.Warn("failed to open browser", "error", err)
 	}
 
 	code, err := util.TerminalPrompt("Enter Grant Type: ")
@@ -53,7 +78,7 @@ func authCodeGrant(config *vault.Config, credentials *vault.Credentials, insecur
 		"code":         {code},
 		"redirect_uri": {config.RedirectURI},
 	}
-	return tokenRequest(values, config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
+	return tokenRequest(ctx, targetParams(values, config), config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
 }
 
 func implicitGrant(config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
@@ -61,10 +86,10 @@ func implicitGrant(config *vault.Config, credentials *vault.Credentials, insecur
 	if err != nil {
 		return nil, err
 	}
-	authUrl := authorizationRequestURL("token", config.AuthorizationEndpoint, credentials.ClientId, config.RedirectURI, config.Scope, state)
+	authUrl := authorizationRequestURL("token", config, credentials.ClientId, state)
 	fmt.Fprintf(os.Stderr, "Open browser and get token from %s\n", authUrl)
 	if err := webbrowser.Open(authUrl); err != nil {
-		log.Printf("Failed to open browser: %v", err)
+		slog.Warn("failed to open browser", "error", err)
 	}
 
 	body, err := util.TerminalPrompt("Enter Token: ")
@@ -74,58 +99,88 @@ func implicitGrant(config *vault.Config, credentials *vault.Credentials, insecur
 
 	var token vault.Tokens
 	if err = json.Unmarshal([]byte(body), &token); err != nil {
-		log.Printf("Failed to parse token response: %v", err)
+		slog.Debug("failed to parse token response", "error", err)
 		return nil, err
 	}
 
 	return &OAuth2TokenResponse{
-		AccessToken:  token.AccessToken,
-		RefreshToken: token.RefreshToken,
-		TokenType:    token.TokenType,
-		ExpiresIn:    token.ExpiresIn,
-		Scope:        token.Scope,
-		IdToken:      token.IdToken,
+		AccessToken:      token.AccessToken,
+		RefreshToken:     token.RefreshToken,
+		TokenType:        token.TokenType,
+		ExpiresIn:        token.ExpiresIn,
+		RefreshExpiresIn: token.RefreshExpiresIn,
+		Scope:            token.Scope,
+		IdToken:          token.IdToken,
 	}, nil
 }
 
-func resourceOwnerPasswordCredentialsGrant(config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
+func resourceOwnerPasswordCredentialsGrant(ctx context.Context, config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
 	values := url.Values{
 		"grant_type": {"password"},
 		"username":   {credentials.Username},
 		"password":   {credentials.Password},
 		"scope":      condVal(strings.Join(strings.Split(config.Scope, ","), " ")),
 	}
-	return tokenRequest(values, config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
+	return tokenRequest(ctx, targetParams(values, config), config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
 }
 
-func clientCredentialsGrant(config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
+func clientCredentialsGrant(ctx context.Context, config *vault.Config, credentials *vault.Credentials, insecure bool) (*OAuth2TokenResponse, error) {
 	values := url.Values{
 		"grant_type": {"client_credentials"},
 		"scope":      condVal(strings.Join(strings.Split(config.Scope, ","), " ")),
 	}
-	return tokenRequest(values, config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
+	return tokenRequest(ctx, targetParams(values, config), config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
 }
 
-func refreshGrant(config *vault.Config, credentials *vault.Credentials, refreshToken string, insecure bool) (*OAuth2TokenResponse, error) {
+func refreshGrant(ctx context.Context, config *vault.Config, credentials *vault.Credentials, refreshToken string, insecure bool) (*OAuth2TokenResponse, error) {
 	values := url.Values{
 		"grant_type":    {"refresh_token"},
 		"refresh_token": {refreshToken},
 		"scope":         condVal(strings.Join(strings.Split(config.Scope, ","), " ")),
 	}
-	return tokenRequest(values, config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
+	return tokenRequest(ctx, targetParams(values, config), config.TokenEndpoint, credentials.ClientId, credentials.ClientSecret, config.UserAgent, insecure)
+}
+
+// targetParams adds the audience and resource indicator parameters of the config
+// and the profile's extra_token_params to the token request
+func targetParams(v url.Values, config *vault.Config) url.Values {
+	addAudience(v, config)
+	addExtraParams(v, config.ExtraTokenParams)
+	return v
 }
 
-func authorizationRequestURL(responseType, authEndpoint, clientId, redirectURI, scope, state string) string {
+// addExtraParams adds the extra params which don't replace a parameter set by aurl, such as
+// grant_type or state. Validation reports those, this guards the ones it can't know of.
+func addExtraParams(v url.Values, params map[string]string) {
+	for name, value := range params {
+		if _, ok := v[name]; !ok {
+			v.Set(name, value)
+		}
+	}
+}
+
+func addAudience(v url.Values, config *vault.Config) {
+	if config.Audience != "" {
+		v.Set("audience", config.Audience)
+	}
+	for _, resource := range config.Resources {
+		v.Add("resource", resource)
+	}
+}
+
+func authorizationRequestURL(responseType string, config *vault.Config, clientId, state string) string {
 	var buf bytes.Buffer
-	buf.WriteString(authEndpoint)
+	buf.WriteString(config.AuthorizationEndpoint)
 	v := url.Values{
 		"response_type": {responseType},
 		"client_id":     {clientId},
-		"redirect_uri":  condVal(redirectURI),
-		"scope":         condVal(strings.Join(strings.Split(scope, ","), " ")),
+		"redirect_uri":  condVal(config.RedirectURI),
+		"scope":         condVal(strings.Join(strings.Split(config.Scope, ","), " ")),
 		"state":         condVal(state),
 	}
-	if strings.Contains(authEndpoint, "?") {
+	addAudience(v, config)
+	addExtraParams(v, config.ExtraAuthParams)
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
 		buf.WriteByte('&')
 	} else {
 		buf.WriteByte('?')
@@ -134,42 +189,51 @@ func authorizationRequestURL(responseType, authEndpoint, clientId, redirectURI,
 	return buf.String()
 }
 
-func tokenRequest(v url.Values, tokenEndpoint, clientId, clientSecret, userAgent string, insecure bool) (*OAuth2TokenResponse, error) {
-	req, err := http.NewRequest("POST", tokenEndpoint, strings.NewReader(v.Encode()))
+// tokenRequest posts the token request to the token endpoint in a client span, a child of the one in ctx
+func tokenRequest(ctx context.Context, v url.Values, tokenEndpoint, clientId, clientSecret, userAgent string, insecure bool) (_ *OAuth2TokenResponse, err error) {
+	ctx, span := telemetry.StartClient(ctx, "POST", attribute.String("http.request.method", "POST"),
+		attribute.String("oauth2.grant_type", v.Get("grant_type")))
+	status := 0
+	defer func() { telemetry.EndResponse(span, status, err) }()
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, strings.NewReader(v.Encode()))
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(attribute.String("url.full", redact.URL(req.URL.String())),
+		attribute.String("server.address", req.URL.Hostname()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("User-Agent", userAgent)
+	telemetry.Inject(ctx, req.Header)
 
 	req.SetBasicAuth(clientId, clientSecret)
 
 	if dumpReq, err := httputil.DumpRequestOut(req, true); err == nil {
-		log.Printf("Token request >>>\n%s\n<<<", string(dumpReq))
+		slog.Debug("token request dump", "dump", redact.Dump(dumpReq))
 	} else {
-		log.Printf("Token request dump failed: %s", err)
+		slog.Debug("token request dump failed", "error", err)
 	}
 
 	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecure,
-			},
-		},
+		Transport: newTransport(insecure),
 	}
-	resp, err := client.Do(req)
+	start := time.Now()
+	timing := newTiming()
+	resp, err := client.Do(timing.trace(req))
 	if err != nil {
-		log.Printf("Token request failed: %s", err.Error())
+		slog.Warn("token request failed", "grant_type", v.Get("grant_type"), "endpoint", tokenEndpoint, "error", err)
 		return nil, err
 	}
 
 	defer resp.Body.Close()
+	status = resp.StatusCode
+	slog.Info("token request", "grant_type", v.Get("grant_type"), "endpoint", tokenEndpoint, "status", resp.StatusCode,
+		"duration_ms", durationMs(time.Since(start)))
 
 	if dumpResp, err := httputil.DumpResponse(resp, true); err == nil {
-		log.Printf("Token response >>>\n%s\n<<<", string(dumpResp))
+		slog.Debug("token response dump", "dump", redact.Dump(dumpResp))
 	} else {
-		log.Printf("Token response dump failed: %s", err)
+		slog.Debug("token response dump failed", "error", err)
 	}
 
 	if resp.StatusCode == 200 {
@@ -177,23 +241,32 @@ func tokenRequest(v url.Values, tokenEndpoint, clientId, clientSecret, userAgent
 		if err != nil {
 			return nil, err
 		}
+		timing.done()
 		var token vault.Tokens
 		if err = json.Unmarshal(body, &token); err != nil {
-			log.Printf("Failed to parse token response: %v", err)
+			slog.Debug("failed to parse token response", "error", err)
 			return nil, err
 		}
 
 		return &OAuth2TokenResponse{
-			AccessToken:  token.AccessToken,
-			RefreshToken: token.RefreshToken,
-			TokenType:    token.TokenType,
-			ExpiresIn:    token.ExpiresIn,
-			Scope:        token.Scope,
-			IdToken:      token.IdToken,
+			AccessToken:      token.AccessToken,
+			RefreshToken:     token.RefreshToken,
+			TokenType:        token.TokenType,
+			ExpiresIn:        token.ExpiresIn,
+			RefreshExpiresIn: token.RefreshExpiresIn,
+			Scope:            token.Scope,
+			IdToken:          token.IdToken,
+			Timing:           timing,
 		}, nil
 	}
 
-	return nil, fmt.Errorf("token request failed with status: %d", resp.StatusCode)
+	oauth2Err := &OAuth2Error{StatusCode: resp.StatusCode}
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		if err := json.Unmarshal(body, oauth2Err); err != nil {
+			slog.Debug("failed to parse token error response", "error", err)
+		}
+	}
+	return nil, oauth2Err
 }
 
 func condVal(v string) []string {
@@ -0,0 +1,273 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/pretty"
+	"github.com/classmethod/aurl/telemetry"
+)
+
+// Pagination follows the next pages of a JSON response, from the Link header (RFC 8288)
+// or from a cursor field of the response
+type Pagination struct {
+	// NextField is the dotted path of the field holding the URL or cursor of the next page
+	NextField string
+	// ItemsField is the dotted path of the array of items in a page. Without it a page which
+	// is an array holds the items, any other page is an item.
+	ItemsField string
+	// CursorParam is the query parameter a cursor of NextField is sent in
+	CursorParam string
+	// MaxPages limits the number of pages requested, 0 for no limit
+	MaxPages int
+	// NDJSON streams the items one per line instead of printing them as one array at the end
+	NDJSON bool
+}
+
+// executePages requests the pages and prints their items. Returns the response of the last page.
+func (r *Request) executePages(keyring keyring.Keyring) (*http.Response, error) {
+	p := r.Paginate
+	var items []json.RawMessage
+	var response *http.Response
+	seen := map[string]bool{}
+	for page := 1; ; page++ {
+		seen[*r.TargetUrl] = true
+		var err error
+		if response, err = r.requestPage(keyring, page); err != nil {
+			return response, err
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return response, err
+		}
+		pageItems, next, err := p.parse(body, response.Header)
+		if err != nil {
+			return response, fmt.Errorf("Page %d: %w", page, err)
+		}
+		slog.Debug("page", "page", page, "items", len(pageItems), "next", next)
+		if p.NDJSON {
+			for _, item := range pageItems {
+				if err := r.printItem(item); err != nil {
+					return response, err
+				}
+			}
+		} else {
+			items = append(items, pageItems...)
+		}
+
+		if next == "" {
+			break
+		}
+		if p.MaxPages > 0 && page >= p.MaxPages {
+			fmt.Fprintf(os.Stderr, "Warning: Stopped after %d pages, there are more\n", page)
+			break
+		}
+		nextURL, err := p.nextURL(response.Request.URL, next)
+		if err != nil {
+			return response, err
+		}
+		if seen[nextURL] {
+			return response, fmt.Errorf("Page %d links to %s which was already requested", page, nextURL)
+		}
+		r.TargetUrl = &nextURL
+	}
+
+	if p.NDJSON {
+		return response, nil
+	}
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return response, err
+	}
+	return response, r.printJSON(data)
+}
+
+// requestPage requests a page with a valid token. A token rejected in the middle of the run,
+// such as one revoked early, is renewed once.
+func (r *Request) requestPage(keyring keyring.Keyring, page int) (*http.Response, error) {
+	// a cached token is reused, one about to expire refreshed
+	if err := r.AcquireToken(telemetry.Context(), keyring); err != nil {
+		return nil, err
+	}
+	response, err := r.doRequest()
+	if page > 1 && response != nil && response.StatusCode == http.StatusUnauthorized {
+		response.Body.Close()
+		if err := r.renewRejectedToken(keyring, "page", page); err != nil {
+			return nil, err
+		}
+		response, err = r.doRequest()
+	}
+	if err == nil && response.StatusCode >= 300 {
+		err = fmt.Errorf("Page %d: %s", page, response.Status)
+	}
+	return response, err
+}
+
+// parse returns the items of a page and the link or cursor of the next page, empty on the last page
+func (p *Pagination) parse(body []byte, header http.Header) ([]json.RawMessage, string, error) {
+	if !json.Valid(body) {
+		return nil, "", errors.New("Response is not JSON")
+	}
+	page := json.RawMessage(bytes.TrimSpace(body))
+
+	var items []json.RawMessage
+	if p.ItemsField != "" {
+		field, ok := jsonField(page, p.ItemsField)
+		if !ok {
+			return nil, "", fmt.Errorf("Response has no field %q", p.ItemsField)
+		}
+		if err := json.Unmarshal(field, &items); err != nil {
+			return nil, "", fmt.Errorf("Field %q is not an array", p.ItemsField)
+		}
+	} else if err := json.Unmarshal(page, &items); err != nil {
+		items = []json.RawMessage{page}
+	}
+
+	if p.NextField == "" {
+		return items, nextLink(header), nil
+	}
+	field, ok := jsonField(page, p.NextField)
+	if !ok {
+		return items, "", nil
+	}
+	var next interface{}
+	json.Unmarshal(field, &next)
+	switch v := next.(type) {
+	case string:
+		return items, v, nil
+	case float64:
+		return items, string(field), nil
+	case nil:
+		return items, "", nil
+	default:
+		return nil, "", fmt.Errorf("Field %q is not a URL or cursor", p.NextField)
+	}
+}
+
+// nextURL resolves the link or cursor of the next page against the URL of the current one
+func (p *Pagination) nextURL(current *url.URL, next string) (string, error) {
+	if p.NextField == "" || strings.Contains(next, "://") || strings.HasPrefix(next, "/") || strings.HasPrefix(next, "?") {
+		u, err := current.Parse(next)
+		if err != nil {
+			return "", fmt.Errorf("Invalid next page %q: %w", next, err)
+		}
+		return u.String(), nil
+	}
+	u := *current
+	query := u.Query()
+	query.Set(p.CursorParam, next)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// jsonField returns the value at the dotted path of a JSON document
+func jsonField(doc json.RawMessage, path string) (json.RawMessage, bool) {
+	for _, key := range strings.Split(path, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &object); err != nil {
+			return nil, false
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, false
+		}
+		doc = value
+	}
+	return doc, true
+}
+
+// nextLink returns the target of the rel="next" link of the Link headers
+func nextLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range splitLinks(value) {
+			target, params, ok := strings.Cut(link, ">")
+			target = strings.TrimSpace(target)
+			if !ok || !strings.HasPrefix(target, "<") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				name, rel, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(rel), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1:]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// splitLinks splits a Link header on the commas between links, not those in URLs or quoted parameters
+func splitLinks(value string) []string {
+	var links []string
+	inURL, inQuote, start := false, false, 0
+	for i, c := range value {
+		switch {
+		case c == '<' && !inQuote:
+			inURL = true
+		case c == '>' && !inQuote:
+			inURL = false
+		case c == '"' && !inURL:
+			inQuote = !inQuote
+		case c == ',' && !inURL && !inQuote:
+			links = append(links, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(links, value[start:])
+}
+
+// printItem writes an item as a line of NDJSON, filtered by --jq
+func (r *Request) printItem(item json.RawMessage) error {
+	if r.Filter != nil {
+		out, err := r.Filter.Run(item, false, false)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, item); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := os.Stdout.Write(b.Bytes())
+	return err
+}
+
+// printJSON writes a JSON document to stdout, filtered by --jq and indented if pretty output is enabled
+func (r *Request) printJSON(data []byte) error {
+	var out []byte
+	var err error
+	switch {
+	case r.Filter != nil:
+		out, err = r.Filter.Run(data, *r.Pretty, *r.Color)
+	case *r.Pretty:
+		out, err = pretty.JSON(data, *r.Color)
+	default:
+		out = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -1,12 +1,11 @@
 package request
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -15,7 +14,12 @@ import (
 	"time"
 
 	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/pretty"
+	"github.com/classmethod/aurl/redact"
+	"github.com/classmethod/aurl/telemetry"
 	"github.com/classmethod/aurl/vault"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Request struct {
@@ -25,105 +29,150 @@ type Request struct {
 	Config       *vault.Config
 	Credentials  *vault.Credentials
 	TokenInfo    *vault.TokenInfo
+	TokenLock    *vault.TokenLock
+	RenewToken   bool
 	Method       *string
 	Headers      *[]string
 	Data         *string
 	Insecure     *bool
+	AllowAnyHost *bool
 	PrintBody    *bool
 	PrintHeaders *bool
+	ShowTiming   *bool
+	WriteOut     *string
+	Pretty       *bool
+	Color        *bool
+	// Filter is the --jq expression applied to the response body, if any
+	Filter   *pretty.Filter
+	Paginate *Pagination
+	Stream   *Stream
+
+	// transport is shared by the requests of a batch
+	transport http.RoundTripper
 
 	TargetUrl *string
+
+	// TokenSource tells where the access token came from: cache, another process, refresh or grant
+	TokenSource   string
+	TokenDuration time.Duration
+	// TokenTiming and Timing are the timings of the token request, if any, and of the request
+	TokenTiming *Timing
+	Timing      *Timing
 }
 
 func (r *Request) Execute(keyring keyring.Keyring) (err error) {
-	log.Printf("Profile = %v", r.Config)
-	if r.TokenInfo == nil || r.TokenInfo.Tokens.AccessToken == "" || r.TokenInfo.IsExpired() {
-		var tokenResponse *OAuth2TokenResponse
-
-		if r.TokenInfo != nil && r.TokenInfo.Tokens != nil && r.TokenInfo.Tokens.RefreshToken != "" {
-			log.Printf("Access token is missing or expired, try to refresh using refresh token")
-			if tokenResponse, err = r.refresh(); err != nil {
-				log.Printf("Token refresh failed: %v", err)
-			}
-		} else {
-			log.Printf("Access token is missing or expired, perform full grant flow")
-			if tokenResponse, err = r.grant(); err != nil {
-				return err
-			}
+	// fail before asking for a token that couldn't be used
+	if target, err := url.Parse(*r.TargetUrl); err == nil {
+		if err := r.checkHost(target); err != nil {
+			return err
 		}
+	}
+	if err := r.AcquireToken(telemetry.Context(), keyring); err != nil {
+		return err
+	}
 
-		log.Printf("Obtained tokens: %v", tokenResponse)
-		r.TokenInfo = &vault.TokenInfo{
-			RequestTimestamp: time.Now().Unix(),
-			Tokens: &vault.Tokens{
-				AccessToken:  tokenResponse.AccessToken,
-				RefreshToken: tokenResponse.RefreshToken,
-				TokenType:    tokenResponse.TokenType,
-				ExpiresIn:    tokenResponse.ExpiresIn,
-				Scope:        tokenResponse.Scope,
-				IdToken:      tokenResponse.IdToken,
-			},
+	var response *http.Response
+	if r.Paginate != nil {
+		if response, err = r.executePages(keyring); err != nil {
+			return err
 		}
-
-		// Save tokens to keyring
-		tkr := &vault.TokenKeyring{Keyring: keyring}
-		if err := tkr.Set(r.Name, r.TokenInfo); err != nil {
-			log.Printf("Failed to save tokens to keyring: %v", err)
-		} else {
-			log.Printf("Tokens saved to keyring")
+	} else {
+		if response, err = r.doRequest(); err != nil {
+			slog.Debug("request failed", "error", err)
+			return err
+		}
+		defer response.Body.Close()
+		if *r.PrintBody && r.Stream != nil && isEventStream(response) {
+			r.printHeaders(response)
+			if response, err = r.executeEvents(keyring, response); err != nil {
+				return err
+			}
+		} else if err := r.doPrint(response); err != nil {
+			return err
 		}
 	}
-
-	response, err := r.doRequest()
-	if err != nil {
-		log.Printf("Request failed: %v", err)
-		return err
+	// the timing and write-out of a paginated request are those of its last page
+	r.Timing.done()
+	if *r.ShowTiming {
+		r.printTiming(os.Stderr)
+	}
+	if *r.WriteOut != "" {
+		fmt.Print(r.writeOut(*r.WriteOut, response))
 	}
-
-	r.doPrint(response)
 	return nil
 }
 
-func (r *Request) refresh() (*OAuth2TokenResponse, error) {
-	return refreshGrant(r.Config, r.Credentials, r.TokenInfo.Tokens.RefreshToken, *r.Insecure)
+// headers returns the headers given on the command line followed by the headers of the
+// profile, which are only added if the command line doesn't set a header of the same name
+func (r *Request) headers() http.Header {
+	headers := http.Header{}
+	for _, header := range *r.Headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) == 2 {
+			headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+	profileHeaders := http.Header{}
+	for _, header := range r.Config.Headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) == 2 && headers.Get(strings.TrimSpace(parts[0])) == "" {
+			profileHeaders.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+	for name, values := range profileHeaders {
+		headers[name] = values
+	}
+
+	if headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", r.Config.UserAgent)
+	}
+	return headers
 }
 
-func (r *Request) grant() (*OAuth2TokenResponse, error) {
-	switch r.Config.GrantType {
-	case "authorization_code":
-		return authCodeGrant(r.Config, r.Credentials, *r.Insecure)
-	case "implicit":
-		// TODO: not enough checked yet
-		return implicitGrant(r.Config, r.Credentials, *r.Insecure)
-	case "password":
-		// TODO: not enough checked yet
-		return resourceOwnerPasswordCredentialsGrant(r.Config, r.Credentials, *r.Insecure)
-	case "client_credentials":
-		return clientCredentialsGrant(r.Config, r.Credentials, *r.Insecure)
-	default:
-		return nil, errors.New("Unknown grant type: " + r.Config.GrantType)
+// addQuery adds the query parameters of the profile which the URL doesn't already have
+func (r *Request) addQuery(u *url.URL) {
+	if len(r.Config.Query) == 0 {
+		return
+	}
+	query := u.Query()
+	profileQuery := url.Values{}
+	for _, param := range r.Config.Query {
+		name, value, _ := strings.Cut(param, "=")
+		if !query.Has(name) {
+			profileQuery.Add(name, value)
+		}
+	}
+	for name, values := range profileQuery {
+		query[name] = values
 	}
+	u.RawQuery = query.Encode()
 }
 
-func (r *Request) doRequest() (*http.Response, error) {
+func (r *Request) doRequest() (resp *http.Response, err error) {
 	body := strings.NewReader(*r.Data)
-	httpReq, err := http.NewRequest(*r.Method, *r.TargetUrl, body)
-	if err != nil {
-		return nil, err
+	ctx, span := telemetry.StartClient(telemetry.Context(), *r.Method, attribute.String("http.request.method", *r.Method))
+	if r.Stream != nil && r.Stream.NoBuffer {
+		ctx = withNoBuffer(ctx)
 	}
-
-	// Initialize Headers from string slice
-	httpReq.Header = http.Header{}
-	for _, header := range *r.Headers {
-		parts := strings.SplitN(header, ":", 2)
-		if len(parts) == 2 {
-			httpReq.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	defer func() {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
 		}
+		telemetry.EndResponse(span, status, err)
+	}()
+	httpReq, err := http.NewRequestWithContext(ctx, *r.Method, *r.TargetUrl, body)
+	if err != nil {
+		return nil, err
 	}
-
-	if httpReq.Header.Get("User-Agent") == "" {
-		httpReq.Header.Set("User-Agent", r.Config.UserAgent)
+	if err := r.checkHost(httpReq.URL); err != nil {
+		return nil, err
 	}
+	r.addQuery(httpReq.URL)
+	httpReq.Header = r.headers()
+	telemetry.Inject(ctx, httpReq.Header)
+	span.SetAttributes(attribute.String("url.full", redact.URL(httpReq.URL.String())),
+		attribute.String("server.address", httpReq.URL.Hostname()))
 
 	if httpReq.Header.Get("Content-Type") == "" {
 		httpReq.Header.Set("Content-Type", r.Config.ContentType)
@@ -131,51 +180,50 @@ func (r *Request) doRequest() (*http.Response, error) {
 	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.TokenInfo.Tokens.AccessToken))
 
 	if dumpReq, err := httputil.DumpRequestOut(httpReq, true); err == nil {
-		log.Printf("Dominant request >>>\n%s\n<<<", string(dumpReq))
+		slog.Debug("request dump", "dump", redact.Dump(dumpReq))
 	} else {
-		log.Printf("Dominant request dump failed: %v", err)
+		slog.Debug("request dump failed", "error", err)
 	}
 
 	client := &http.Client{
 		CheckRedirect: func(redirectRequest *http.Request, via []*http.Request) error {
-			log.Printf("Redirect to %s", redirectRequest.URL.String())
-			log.Printf("Original request Host = %s", httpReq.URL.String())
-			// Initialize Headers from string slice
-			redirectRequest.Header = http.Header{}
-			for _, header := range *r.Headers {
-				parts := strings.SplitN(header, ":", 2)
-				if len(parts) == 2 {
-					redirectRequest.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
-				}
-			}
-			if redirectRequest.Header.Get("User-Agent") == "" {
-				redirectRequest.Header.Set("User-Agent", r.Config.UserAgent)
+			slog.Info("redirect", "hop", len(via), "status", redirectRequest.Response.StatusCode,
+				"location", redact.URL(redirectRequest.URL.String()), "original", redact.URL(httpReq.URL.String()))
+			if err := r.checkHost(redirectRequest.URL); err != nil {
+				return fmt.Errorf("Redirect refused: %w", err)
 			}
-			if matchServer(redirectRequest.URL, httpReq.URL) {
-				log.Printf("Propagate authorization header")
-				redirectRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.TokenInfo.Tokens.AccessToken))
-				return nil
-			} else {
-				return errors.New("Redirect to non-same origin resource server")
+			if via[len(via)-1].URL.Scheme == "https" && redirectRequest.URL.Scheme != "https" && !*r.AllowAnyHost {
+				return fmt.Errorf("Redirect refused: %s would send the access token without TLS", redirectRequest.URL.Redacted())
 			}
+			redirectRequest.Header = r.headers()
+			telemetry.Inject(ctx, redirectRequest.Header)
+			span.AddEvent("redirect", trace.WithAttributes(attribute.String("url.full", redact.URL(redirectRequest.URL.String()))))
+			r.addQuery(redirectRequest.URL)
+			slog.Debug("propagate authorization header")
+			redirectRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.TokenInfo.Tokens.AccessToken))
+			return nil
 		},
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: *r.Insecure,
-			},
-		},
+		Transport: r.roundTripper(),
 	}
-	resp, err := client.Do(httpReq)
+	start := time.Now()
+	r.Timing = newTiming()
+	resp, err = client.Do(r.Timing.trace(httpReq))
 	if err != nil {
-		log.Printf("Dominant request failed")
+		slog.Warn("request failed", "method", httpReq.Method, "url", redact.URL(httpReq.URL.String()), "error", err)
 		return resp, err
 	}
-	defer resp.Body.Close()
+	// the body of a stream is left to the caller, the others are read ahead for the dump
+	streaming := isStreaming(resp)
+	if !streaming {
+		defer resp.Body.Close()
+	}
+	slog.Info("response", "method", httpReq.Method, "url", redact.URL(resp.Request.URL.String()), "status", resp.StatusCode,
+		"duration_ms", durationMs(time.Since(start)))
 
-	if dumpResp, err := httputil.DumpResponse(resp, true); err == nil {
-		log.Printf("Dominant response >>>\n%s\n<<<", string(dumpResp))
+	if dumpResp, err := httputil.DumpResponse(resp, !streaming); err == nil {
+		slog.Debug("response dump", "dump", redact.Dump(dumpResp))
 	} else {
-		log.Printf("Dominant response dump failed: %v", err)
+		slog.Debug("response dump failed", "error", err)
 	}
 
 	if resp.StatusCode == 401 {
@@ -185,42 +233,90 @@ func (r *Request) doRequest() (*http.Response, error) {
 	}
 }
 
-func (r *Request) doPrint(response *http.Response) {
+func (r *Request) doPrint(response *http.Response) error {
 	if response == nil {
-		return
+		return nil
+	}
+	r.printHeaders(response)
+
+	if *r.PrintBody {
+		slog.Debug("printing body")
+		return r.printBody(response)
 	}
+	slog.Debug("no printing body")
+	return nil
+}
+
+// printHeaders writes the response headers as JSON to stdout with --print-headers
+func (r *Request) printHeaders(response *http.Response) {
 	if *r.PrintHeaders {
-		log.Println("Printing headers")
+		slog.Debug("printing headers")
 		headers, err := json.Marshal(response.Header)
+		if err == nil && *r.Pretty {
+			headers, err = pretty.JSON(headers, *r.Color)
+		} else if err == nil {
+			headers = append(headers, '\n')
+		}
 		if err == nil {
-			fmt.Println(string(headers))
+			os.Stdout.Write(headers)
 		} else {
-			log.Println("Header marshaling failed: ", err)
-			log.Println("Continue...")
+			slog.Debug("header marshaling failed, continue", "error", err)
 			fmt.Println("{}")
 		}
 	} else {
-		log.Println("No printing headers")
+		slog.Debug("no printing headers")
 	}
+}
 
-	if *r.PrintBody {
-		log.Println("Printing body")
-		_, err := io.Copy(os.Stdout, response.Body)
-		if err != nil {
-			log.Printf("Error on read: %v", err)
-			log.Println()
+// printBody writes the response body to stdout, filtered by --jq and indented if pretty output is enabled
+func (r *Request) printBody(response *http.Response) error {
+	if isStreaming(response) {
+		return r.printStream(response)
+	}
+	if r.Filter == nil && !*r.Pretty {
+		if _, err := io.Copy(os.Stdout, response.Body); err != nil {
+			slog.Debug("error on read", "error", err)
 		}
-	} else {
-		log.Println("No printing body")
+		return nil
 	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	contentType := response.Header.Get("Content-Type")
+	out := data
+	switch {
+	case r.Filter != nil:
+		if out, err = r.Filter.Run(data, *r.Pretty, *r.Color); err != nil {
+			return err
+		}
+	case pretty.IsJSON(contentType):
+		out, err = pretty.JSON(data, *r.Color)
+	case pretty.IsXML(contentType):
+		out, err = pretty.XML(data, *r.Color)
+	}
+	if err != nil {
+		// print malformed documents as they are
+		slog.Debug("response can't be pretty printed", "content_type", contentType, "error", err)
+		out = data
+	}
+	_, err = os.Stdout.Write(out)
+	return err
 }
 
-func matchServer(a *url.URL, b *url.URL) bool {
-	if a.Scheme != b.Scheme {
-		return false
+// roundTripper returns the transport shared by a batch, or a new one
+func (r *Request) roundTripper() http.RoundTripper {
+	if r.transport != nil {
+		return r.transport
 	}
-	if a.Host != b.Host {
-		return false
+	return newTransport(*r.Insecure)
+}
+
+// checkHost refuses to send the access token to hosts not allowed by the profile, unless --allow-any-host is given
+func (r *Request) checkHost(u *url.URL) error {
+	if *r.AllowAnyHost || r.Config.IsAllowedHost(u) {
+		return nil
 	}
-	return true
+	return fmt.Errorf("Host %s is not allowed by profile '%s' (allowed_hosts: %s), set allowed_hosts or use --allow-any-host to send the token anyway",
+		u.Host, r.Name, strings.Join(r.Config.HostPatterns(), ", "))
 }
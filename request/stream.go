@@ -0,0 +1,252 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/telemetry"
+)
+
+// Stream sets how streaming responses, such as server-sent events and NDJSON, are printed
+type Stream struct {
+	// NoBuffer prints any response as it arrives, without reading it ahead for pretty printing or logging
+	NoBuffer bool
+	// JSON prints server-sent events as JSON lines instead of as received
+	JSON bool
+	// Reconnect is the number of times in a row an event stream is reopened when it ends
+	Reconnect int
+}
+
+// defaultRetry is the reconnection time of an event stream until the server sets one
+const defaultRetry = 3 * time.Second
+
+// streamingMediaTypes are read as they arrive rather than read ahead
+var streamingMediaTypes = map[string]bool{
+	"text/event-stream":       true,
+	"application/x-ndjson":    true,
+	"application/ndjson":      true,
+	"application/jsonl":       true,
+	"application/x-jsonlines": true,
+}
+
+type noBufferKey struct{}
+
+// withNoBuffer marks the request of ctx as one whose response must not be read ahead
+func withNoBuffer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noBufferKey{}, true)
+}
+
+// isStreaming reports whether the response body is a stream which must not be read ahead, such as
+// server-sent events, or the response of a request made with --no-buffer
+func isStreaming(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Context().Value(noBufferKey{}) != nil {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return streamingMediaTypes[mediaType]
+}
+
+// isEventStream reports whether the response is a stream of server-sent events
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// printStream writes the response body as it arrives. Lines of JSON are filtered one by one by --jq.
+func (r *Request) printStream(response *http.Response) error {
+	if r.Filter == nil {
+		_, err := io.Copy(os.Stdout, response.Body)
+		return err
+	}
+	reader := bufio.NewReader(response.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := strings.TrimSpace(string(line)); trimmed != "" {
+			if json.Valid([]byte(trimmed)) {
+				if err := r.printItem(json.RawMessage(trimmed)); err != nil {
+					return err
+				}
+			} else if _, err := os.Stdout.Write(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Event is a server-sent event, as printed by --sse-json
+type Event struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+	// Data is embedded as JSON if it is JSON, as a string otherwise
+	Data interface{} `json:"data"`
+}
+
+// eventStream is the state of an event stream kept across reconnections
+type eventStream struct {
+	lastEventID string
+	retry       time.Duration
+	// received counts the events of the current connection
+	received int
+}
+
+// executeEvents prints the events of the response and, with --reconnect, reopens the stream when it ends,
+// sending the last event ID. Returns the response of the last connection.
+func (r *Request) executeEvents(keyring keyring.Keyring, response *http.Response) (*http.Response, error) {
+	s := &eventStream{retry: defaultRetry}
+	// reconnects counts the reconnections in a row which delivered no event
+	reconnects := 0
+	for {
+		s.received = 0
+		err := r.printEvents(response, s)
+		response.Body.Close()
+		if r.Stream.Reconnect == 0 {
+			return response, err
+		}
+		if s.received > 0 {
+			reconnects = 0
+		}
+		if reconnects >= r.Stream.Reconnect {
+			fmt.Fprintf(os.Stderr, "Warning: Stopped reconnecting after %d attempts without events\n", reconnects)
+			return response, err
+		}
+		reconnects++
+		slog.Info("stream reconnect", "attempt", reconnects, "last_event_id", s.lastEventID, "error", err,
+			"delay_ms", durationMs(s.retry))
+		time.Sleep(s.retry)
+
+		if response, err = r.reconnect(keyring, s.lastEventID); err != nil {
+			return response, err
+		}
+		// the server tells the client to stop reconnecting with 204
+		if response.StatusCode == http.StatusNoContent {
+			response.Body.Close()
+			return response, nil
+		}
+		if !isEventStream(response) {
+			response.Body.Close()
+			return response, fmt.Errorf("Reconnect %d: %s, not an event stream", reconnects, response.Status)
+		}
+	}
+}
+
+// reconnect requests the stream again with a valid token, which is renewed once if it is rejected
+func (r *Request) reconnect(keyring keyring.Keyring, lastEventID string) (*http.Response, error) {
+	headers := []string{}
+	for _, header := range *r.Headers {
+		name, _, _ := strings.Cut(header, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Last-Event-ID") {
+			headers = append(headers, header)
+		}
+	}
+	if lastEventID != "" {
+		headers = append(headers, "Last-Event-ID: "+lastEventID)
+	}
+	r.Headers = &headers
+
+	// a cached token is reused, one about to expire refreshed
+	if err := r.AcquireToken(telemetry.Context(), keyring); err != nil {
+		return nil, err
+	}
+	response, err := r.doRequest()
+	if response != nil && response.StatusCode == http.StatusUnauthorized {
+		response.Body.Close()
+		if err := r.renewRejectedToken(keyring); err != nil {
+			return nil, err
+		}
+		response, err = r.doRequest()
+	}
+	return response, err
+}
+
+// printEvents parses the server-sent events of the response as they arrive and prints them,
+// as received or as JSON lines. The lines are echoed as they come so comments and keep-alives show too.
+func (r *Request) printEvents(response *http.Response, s *eventStream) error {
+	reader := bufio.NewReader(response.Body)
+	var data strings.Builder
+	eventType := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !r.Stream.JSON {
+			if _, err := io.WriteString(os.Stdout, line); err != nil {
+				return err
+			}
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// a blank line dispatches the event
+			if data.Len() > 0 {
+				s.received++
+				if err := r.printEvent(s.lastEventID, eventType, strings.TrimSuffix(data.String(), "\n")); err != nil {
+					return err
+				}
+			}
+			data.Reset()
+			eventType = ""
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data.WriteString(value + "\n")
+		case "event":
+			eventType = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// printEvent prints an event as a JSON line with --sse-json, filtered by --jq
+func (r *Request) printEvent(id, eventType, data string) error {
+	if !r.Stream.JSON {
+		return nil
+	}
+	if eventType == "" {
+		eventType = "message"
+	}
+	event := Event{ID: id, Event: eventType, Data: data}
+	if json.Valid([]byte(data)) {
+		event.Data = json.RawMessage(data)
+	}
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(event); err != nil {
+		return err
+	}
+	return r.printItem(line.Bytes())
+}
@@ -0,0 +1,143 @@
+package request
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Timing is the breakdown of the time taken by an HTTP request, including its redirects
+type Timing struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// FirstByte and Total are measured from the start of the request
+	FirstByte time.Duration
+	Total     time.Duration
+
+	mu                                      sync.Mutex
+	start, dnsStart, connectStart, tlsStart time.Time
+}
+
+func newTiming() *Timing {
+	return &Timing{start: time.Now()}
+}
+
+// trace returns req with a context collecting the timings of its connections
+func (t *Timing) trace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.add(&t.DNS, &t.dnsStart) },
+		ConnectStart: func(string, string) {
+			t.mark(&t.connectStart)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.add(&t.Connect, &t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.add(&t.TLS, &t.tlsStart) },
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.FirstByte = time.Since(t.start)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// mark records the start of a phase, unless a parallel attempt already started it
+func (t *Timing) mark(start *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start.IsZero() {
+		*start = time.Now()
+	}
+}
+
+// add adds the time since the start of a phase to d
+func (t *Timing) add(d *time.Duration, start *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !start.IsZero() {
+		*d += time.Since(*start)
+		*start = time.Time{}
+	}
+}
+
+// done records the total time, once the response has been read
+func (t *Timing) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Total = time.Since(t.start)
+}
+
+// printTiming writes the timings of the token acquisition and the request as a table
+func (r *Request) printTiming(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tDNS\tCONNECT\tTLS\tFIRST BYTE\tTOTAL")
+	fmt.Fprintf(tw, "token acquisition (%s)\t\t\t\t\t%s\n", r.TokenSource, formatDuration(r.TokenDuration))
+	if r.TokenTiming != nil {
+		printTimingRow(tw, "token request", r.TokenTiming)
+	}
+	if r.Timing != nil {
+		printTimingRow(tw, "request", r.Timing)
+	}
+	tw.Flush()
+}
+
+func printTimingRow(w io.Writer, name string, t *Timing) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, formatDuration(t.DNS), formatDuration(t.Connect), formatDuration(t.TLS),
+		formatDuration(t.FirstByte), formatDuration(t.Total))
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+var writeOutPattern = regexp.MustCompile(`%\{([a-z_]+)\}`)
+
+var writeOutEscapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\\`, `\`)
+
+// writeOut expands the %{name} variables of the --write-out format, as curl does
+func (r *Request) writeOut(format string, response *http.Response) string {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.6f", d.Seconds())
+	}
+	t := r.Timing
+	variables := map[string]string{
+		"http_code":          fmt.Sprintf("%03d", response.StatusCode),
+		"response_code":      fmt.Sprintf("%03d", response.StatusCode),
+		"content_type":       response.Header.Get("Content-Type"),
+		"url_effective":      response.Request.URL.String(),
+		"time_namelookup":    seconds(t.DNS),
+		"time_connect":       seconds(t.DNS + t.Connect),
+		"time_appconnect":    "0.000000",
+		"time_starttransfer": seconds(t.FirstByte),
+		"time_total":         seconds(t.Total),
+		"time_token":         seconds(r.TokenDuration),
+	}
+	if t.TLS > 0 {
+		variables["time_appconnect"] = seconds(t.DNS + t.Connect + t.TLS)
+	}
+	return writeOutPattern.ReplaceAllStringFunc(writeOutEscapes.Replace(format), func(m string) string {
+		name := writeOutPattern.FindStringSubmatch(m)[1]
+		value, ok := variables[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: unknown --write-out variable %q\n", name)
+		}
+		return value
+	})
+}
@@ -0,0 +1,242 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/redact"
+	"github.com/classmethod/aurl/telemetry"
+	"github.com/classmethod/aurl/vault"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AcquireToken makes sure r.TokenInfo holds a valid access token, refreshing or
+// granting a new one and saving it to the keyring when necessary. Its span is a child of the one in ctx.
+func (r *Request) AcquireToken(ctx context.Context, keyring keyring.Keyring) (err error) {
+	ctx, span := telemetry.Start(ctx, "token acquisition",
+		attribute.String("aurl.profile", r.Name), attribute.String("oauth2.grant_type", r.Config.GrantType))
+	defer func() { telemetry.End(span, err) }()
+	keyring = telemetry.WithContext(ctx, keyring)
+	start := time.Now()
+	defer func() { r.TokenDuration = time.Since(start) }()
+
+	slog.Debug("profile config", "config", redact.String(fmt.Sprintf("%v", r.Config)))
+	cache := "hit"
+	switch {
+	case r.hasValidToken():
+	case r.TokenInfo == nil:
+		cache = "miss"
+	default:
+		cache = "expired"
+	}
+	slog.Info("token cache", "profile", r.Name, "result", cache)
+	span.SetAttributes(attribute.String("aurl.token.cache", cache))
+	if cache == "hit" {
+		r.TokenSource = "cache"
+		return nil
+	}
+	if r.TokenLock != nil {
+		if err := r.TokenLock.Lock(); err != nil {
+			return err
+		}
+		defer r.TokenLock.Unlock()
+		if r.reloadToken(keyring) {
+			r.TokenSource = "another process"
+			span.SetAttributes(attribute.String("aurl.token.source", r.TokenSource))
+			return nil
+		}
+	}
+
+	previous := r.TokenInfo
+	r.TokenSource = "refresh"
+	tokenResponse := r.tryRefresh(ctx)
+	if tokenResponse == nil {
+		slog.Debug("access token is missing or expired, perform full grant flow")
+		r.TokenSource = "grant"
+		if tokenResponse, err = r.grant(ctx); err != nil {
+			return err
+		}
+		previous = nil
+	}
+	span.SetAttributes(attribute.String("aurl.token.source", r.TokenSource))
+	r.TokenTiming = tokenResponse.Timing
+	return r.storeToken(keyring, tokenResponse, previous)
+}
+
+// RefreshToken renews a token which expires within margin without any user interaction,
+// using the refresh token or, for the client credentials grant, a new grant
+func (r *Request) RefreshToken(ctx context.Context, keyring keyring.Keyring, margin time.Duration) (err error) {
+	ctx, span := telemetry.Start(ctx, "token refresh",
+		attribute.String("aurl.profile", r.Name), attribute.String("oauth2.grant_type", r.Config.GrantType))
+	defer func() { telemetry.End(span, err) }()
+	keyring = telemetry.WithContext(ctx, keyring)
+
+	if r.TokenLock != nil {
+		if err := r.TokenLock.Lock(); err != nil {
+			return err
+		}
+		defer r.TokenLock.Unlock()
+		r.reloadToken(keyring)
+	}
+	if r.TokenInfo != nil && !r.TokenInfo.ExpiresWithin(margin) {
+		return nil
+	}
+
+	previous := r.TokenInfo
+	tokenResponse := r.tryRefresh(ctx)
+	if tokenResponse == nil {
+		if r.Config.GrantType != "client_credentials" {
+			return fmt.Errorf("Token of profile %q cannot be refreshed without user interaction", r.Name)
+		}
+		if tokenResponse, err = r.grant(ctx); err != nil {
+			return err
+		}
+		previous = nil
+	}
+	return r.storeToken(keyring, tokenResponse, previous)
+}
+
+// renewRejectedToken grants or refreshes a token in place of one the server rejected with 401 although
+// it looked valid, such as one revoked early. args are logged with the event.
+func (r *Request) renewRejectedToken(keyring keyring.Keyring, args ...interface{}) error {
+	slog.Info("token rejected, renewing it", append([]interface{}{"profile", r.Name}, args...)...)
+	r.TokenInfo.Tokens.AccessToken = ""
+	renewToken := r.RenewToken
+	r.RenewToken = true
+	defer func() { r.RenewToken = renewToken }()
+	return r.AcquireToken(telemetry.Context(), keyring)
+}
+
+func (r *Request) hasValidToken() bool {
+	return r.TokenInfo != nil && r.TokenInfo.Tokens != nil && r.TokenInfo.Tokens.AccessToken != "" && !r.TokenInfo.IsExpired()
+}
+
+// reloadToken picks up a token stored by another process while we were waiting for the
+// token lock. Returns true if that token is still valid and can be used as is.
+func (r *Request) reloadToken(keyring keyring.Keyring) bool {
+	if r.RenewToken {
+		return false
+	}
+	tkr := &vault.TokenKeyring{Keyring: keyring}
+	tokenInfo, err := tkr.Get(r.Config.TokenCacheKey())
+	if err != nil || tokenInfo.Tokens == nil || len(vault.MissingScopes(r.Config.Scopes(), tokenInfo.Tokens.Scope)) > 0 {
+		return false
+	}
+	// Even an expired token is useful: its refresh token is the most recent one
+	r.TokenInfo = tokenInfo
+	if r.hasValidToken() {
+		slog.Info("token cache", "profile", r.Name, "result", "hit", "stored_by", "another process")
+		return true
+	}
+	return false
+}
+
+// tryRefresh uses the refresh token of r.TokenInfo if there is a usable one.
+// Returns nil if no refresh was possible.
+func (r *Request) tryRefresh(ctx context.Context) *OAuth2TokenResponse {
+	previous := r.TokenInfo
+	if previous == nil || previous.Tokens == nil || previous.Tokens.RefreshToken == "" {
+		return nil
+	}
+	if previous.IsRefreshExpired() {
+		slog.Debug("refresh token expired", "expired_at", time.Unix(previous.RefreshExpiresTimestamp, 0))
+		return nil
+	}
+
+	slog.Debug("access token is missing or expired, try to refresh using refresh token")
+	tokenResponse, err := r.refresh(ctx)
+	if err != nil {
+		slog.Warn("token refresh failed", "profile", r.Name, "error", err)
+		var oauth2Err *OAuth2Error
+		if errors.As(err, &oauth2Err) && oauth2Err.Code == "invalid_grant" {
+			fmt.Fprintf(os.Stderr, "Refresh token for profile %q was rejected (%v).\n"+
+				"It has probably been rotated and reused, e.g. by another aurl process or a copied keyring; "+
+				"the authorization server may have revoked the whole token family. Performing a full grant.\n", r.Name, err)
+		}
+		return nil
+	}
+	return tokenResponse
+}
+
+// storeToken replaces r.TokenInfo with the token response and saves it to the keyring.
+// previous is the token the response was refreshed from, nil after a full grant.
+func (r *Request) storeToken(keyring keyring.Keyring, tokenResponse *OAuth2TokenResponse, previous *vault.TokenInfo) error {
+	if missing := vault.MissingScopes(r.Config.Scopes(), tokenResponse.Scope); len(missing) > 0 {
+		return fmt.Errorf("Granted scope %q does not cover requested scopes %q", tokenResponse.Scope, strings.Join(missing, " "))
+	}
+
+	slog.Debug("obtained tokens", "type", tokenResponse.TokenType, "scope", tokenResponse.Scope, "expires_in", formatSeconds(tokenResponse.ExpiresIn),
+		"refresh_token", tokenResponse.RefreshToken != "", "id_token", tokenResponse.IdToken != "")
+	now := time.Now().Unix()
+	r.TokenInfo = &vault.TokenInfo{
+		RequestTimestamp: now,
+		Tokens: &vault.Tokens{
+			AccessToken:      tokenResponse.AccessToken,
+			RefreshToken:     tokenResponse.RefreshToken,
+			TokenType:        tokenResponse.TokenType,
+			ExpiresIn:        tokenResponse.ExpiresIn,
+			RefreshExpiresIn: tokenResponse.RefreshExpiresIn,
+			Scope:            tokenResponse.Scope,
+			IdToken:          tokenResponse.IdToken,
+		},
+	}
+	switch {
+	case tokenResponse.RefreshToken == "" && previous != nil:
+		// The server is not rotating refresh tokens (RFC 6749 section 6), keep using the previous one
+		slog.Debug("no refresh token in refresh response, keeping the previous one")
+		r.TokenInfo.Tokens.RefreshToken = previous.Tokens.RefreshToken
+		r.TokenInfo.Tokens.RefreshExpiresIn = previous.Tokens.RefreshExpiresIn
+		r.TokenInfo.RefreshExpiresTimestamp = previous.RefreshExpiresTimestamp
+	case tokenResponse.RefreshExpiresIn != nil && *tokenResponse.RefreshExpiresIn > 0:
+		r.TokenInfo.RefreshExpiresTimestamp = now + *tokenResponse.RefreshExpiresIn
+	}
+	if previous != nil && tokenResponse.RefreshToken != "" && tokenResponse.RefreshToken != previous.Tokens.RefreshToken {
+		slog.Debug("refresh token has been rotated")
+	}
+
+	// Save tokens to keyring
+	tkr := &vault.TokenKeyring{Keyring: keyring}
+	if err := tkr.Set(r.Config.TokenCacheKey(), r.TokenInfo); err != nil {
+		slog.Warn("failed to save tokens to keyring", "error", err)
+	} else {
+		slog.Debug("tokens saved to keyring")
+	}
+	return nil
+}
+
+func (r *Request) refresh(ctx context.Context) (*OAuth2TokenResponse, error) {
+	slog.Info("token refresh", "profile", r.Name)
+	return refreshGrant(ctx, r.Config, r.Credentials, r.TokenInfo.Tokens.RefreshToken, *r.Insecure)
+}
+
+func (r *Request) grant(ctx context.Context) (*OAuth2TokenResponse, error) {
+	slog.Info("token grant", "profile", r.Name, "grant_type", r.Config.GrantType)
+	switch r.Config.GrantType {
+	case "authorization_code":
+		return authCodeGrant(ctx, r.Config, r.Credentials, *r.Insecure)
+	case "implicit":
+		// TODO: not enough checked yet
+		return implicitGrant(r.Config, r.Credentials, *r.Insecure)
+	case "password":
+		// TODO: not enough checked yet
+		return resourceOwnerPasswordCredentialsGrant(ctx, r.Config, r.Credentials, *r.Insecure)
+	case "client_credentials":
+		return clientCredentialsGrant(ctx, r.Config, r.Credentials, *r.Insecure)
+	default:
+		return nil, errors.New("Unknown grant type: " + r.Config.GrantType)
+	}
+}
+
+// formatSeconds formats an optional expires_in value for the log
+func formatSeconds(seconds *int64) string {
+	if seconds == nil {
+		return "unset"
+	}
+	return (time.Duration(*seconds) * time.Second).String()
+}
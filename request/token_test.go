@@ -0,0 +1,95 @@
+package request
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/telemetry"
+	"github.com/classmethod/aurl/vault"
+)
+
+// exportedSpan is the part of a span written by the file exporter the test looks at
+type exportedSpan struct {
+	Name        string
+	SpanKind    int
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ TraceID, SpanID string }
+}
+
+func TestAcquireTokenSpans(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"abc","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_SDK_DISABLED", "")
+	t.Setenv(telemetry.FileEnvVar, path)
+	if err := telemetry.Setup("token", "test"); err != nil {
+		t.Fatal(err)
+	}
+	insecure := false
+	r := &Request{
+		Name:        "prod",
+		Config:      &vault.Config{Name: "prod", GrantType: "client_credentials", TokenEndpoint: server.URL + "/token"},
+		Credentials: &vault.Credentials{ClientId: "client", ClientSecret: "secret"},
+		Insecure:    &insecure,
+	}
+	kr := &telemetry.Keyring{Keyring: keyring.NewArrayKeyring(nil)}
+	err := r.AcquireToken(telemetry.Context(), kr)
+	telemetry.Shutdown(0)
+	if err != nil {
+		t.Fatalf("AcquireToken: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := map[string]exportedSpan{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var span exportedSpan
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatalf("Invalid span %s: %v", scanner.Text(), err)
+		}
+		spans[span.Name] = span
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	root, acquisition, post, set := spans["aurl token"], spans["token acquisition"], spans["POST"], spans["keyring set"]
+	for name, span := range map[string]exportedSpan{"aurl token": root, "token acquisition": acquisition, "POST": post, "keyring set": set} {
+		if span.Name == "" {
+			t.Fatalf("No %q span in %v", name, spans)
+		}
+	}
+	if acquisition.Parent.SpanID != root.SpanContext.SpanID {
+		t.Errorf("token acquisition is a child of %s, want the root span %s", acquisition.Parent.SpanID, root.SpanContext.SpanID)
+	}
+	for name, span := range map[string]exportedSpan{"POST": post, "keyring set": set} {
+		if span.Parent.SpanID != acquisition.SpanContext.SpanID {
+			t.Errorf("%s is a child of %s, want token acquisition %s", name, span.Parent.SpanID, acquisition.SpanContext.SpanID)
+		}
+	}
+	// trace.SpanKindClient
+	if post.SpanKind != 3 {
+		t.Errorf("POST span kind = %d, want client", post.SpanKind)
+	}
+	if want := "00-" + post.SpanContext.TraceID + "-" + post.SpanContext.SpanID + "-01"; traceparent != want {
+		t.Errorf("traceparent = %q, want %q", traceparent, want)
+	}
+}
@@ -0,0 +1,88 @@
+package request
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/classmethod/aurl/redact"
+)
+
+// Tracer records the HTTP exchanges of token and resource requests, like curl --trace
+type Tracer struct {
+	W io.Writer
+	// Hex writes the data as a hex dump (--trace) rather than plain text (--trace-ascii)
+	Hex bool
+
+	mu sync.Mutex
+}
+
+// Trace is set by --trace and --trace-ascii
+var Trace *Tracer
+
+// newTransport returns the transport of the token and resource requests
+func newTransport(insecure bool) http.RoundTripper {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+		},
+	}
+	var rt http.RoundTripper = transport
+	if Archive != nil {
+		rt = &harTransport{next: rt, har: Archive}
+	}
+	if Trace != nil {
+		rt = &tracingTransport{next: rt, tracer: Trace}
+	}
+	return rt
+}
+
+type tracingTransport struct {
+	next   http.RoundTripper
+	tracer *Tracer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	t.tracer.info("%s %s %s", start.Format(time.RFC3339Nano), req.Method, redact.URL(req.URL.String()))
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		t.tracer.data("=> Send request", redact.Dump(dump))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.tracer.info("%s failed after %s: %v", req.URL.Host, time.Since(start), err)
+		return resp, err
+	}
+	if dump, err := httputil.DumpResponse(resp, !isStreaming(resp)); err == nil {
+		t.tracer.data(fmt.Sprintf("<= Recv response after %s", time.Since(start)), redact.Dump(dump))
+	}
+	return resp, nil
+}
+
+func (t *Tracer) info(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.W, "== Info: "+format+"\n", args...)
+}
+
+func (t *Tracer) data(header, data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.W, "%s, %d bytes\n", header, len(data))
+	if t.Hex {
+		fmt.Fprint(t.W, hex.Dump([]byte(data)))
+		return
+	}
+	fmt.Fprintln(t.W, data)
+}
+
+// durationMs returns d in milliseconds for log events
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,74 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/byteness/keyring"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Keyring is a keyring.Keyring recording a span for each operation
+type Keyring struct {
+	Keyring keyring.Keyring
+
+	// ctx holds the parent span of the operations, the root span if nil
+	ctx context.Context
+}
+
+// WithContext returns kr recording its spans as children of the span in ctx, if it is a Keyring
+func WithContext(ctx context.Context, kr keyring.Keyring) keyring.Keyring {
+	k, ok := kr.(*Keyring)
+	if !ok {
+		return kr
+	}
+	return &Keyring{Keyring: k.Keyring, ctx: ctx}
+}
+
+func (k *Keyring) context() context.Context {
+	if k.ctx == nil {
+		return Context()
+	}
+	return k.ctx
+}
+
+func (k *Keyring) Get(key string) (keyring.Item, error) {
+	_, span := Start(k.context(), "keyring get", attribute.String("aurl.keyring.key", key))
+	item, err := k.Keyring.Get(key)
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		// a missing item, such as an uncached token, is not a failure
+		span.SetAttributes(attribute.Bool("aurl.keyring.found", false))
+		End(span, nil)
+		return item, err
+	}
+	End(span, err)
+	return item, err
+}
+
+func (k *Keyring) GetMetadata(key string) (keyring.Metadata, error) {
+	_, span := Start(k.context(), "keyring get metadata", attribute.String("aurl.keyring.key", key))
+	metadata, err := k.Keyring.GetMetadata(key)
+	End(span, err)
+	return metadata, err
+}
+
+func (k *Keyring) Set(item keyring.Item) error {
+	_, span := Start(k.context(), "keyring set", attribute.String("aurl.keyring.key", item.Key))
+	err := k.Keyring.Set(item)
+	End(span, err)
+	return err
+}
+
+func (k *Keyring) Remove(key string) error {
+	_, span := Start(k.context(), "keyring remove", attribute.String("aurl.keyring.key", key))
+	err := k.Keyring.Remove(key)
+	End(span, err)
+	return err
+}
+
+func (k *Keyring) Keys() ([]string, error) {
+	_, span := Start(k.context(), "keyring keys")
+	keys, err := k.Keyring.Keys()
+	End(span, err)
+	return keys, err
+}
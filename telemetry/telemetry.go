@@ -0,0 +1,181 @@
+// Package telemetry traces aurl with OpenTelemetry when enabled by the OTEL_* environment variables
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FileEnvVar names the file the spans are appended to as JSON lines by the file exporter
+const FileEnvVar = "AURL_OTEL_TRACES_FILE"
+
+const instrumentationName = "github.com/classmethod/aurl"
+
+var (
+	provider *sdktrace.TracerProvider
+	rootCtx  = context.Background()
+	rootSpan trace.Span
+	closer   io.Closer
+
+	propagator = propagation.TraceContext{}
+)
+
+// exporterName returns the exporter selected by OTEL_TRACES_EXPORTER. Tracing is off unless
+// an exporter, an OTLP endpoint or a trace file is configured.
+func exporterName() string {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return "none"
+	}
+	if name := os.Getenv("OTEL_TRACES_EXPORTER"); name != "" {
+		return strings.ToLower(name)
+	}
+	switch {
+	case os.Getenv(FileEnvVar) != "":
+		return "file"
+	case os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "":
+		return "otlp"
+	}
+	return "none"
+}
+
+func newExporter(ctx context.Context, name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "otlp":
+		if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/protobuf" {
+			return nil, fmt.Errorf("OTLP protocol %q is not supported, use http/protobuf", protocol)
+		}
+		return otlptracehttp.New(ctx)
+	case "console":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint())
+	case "file":
+		path := os.Getenv(FileEnvVar)
+		if path == "" {
+			return nil, fmt.Errorf("%s must be set for the file exporter", FileEnvVar)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening trace file: %w", err)
+		}
+		closer = f
+		return stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("Unsupported OTEL_TRACES_EXPORTER %q, use otlp, console, file or none", name)
+	}
+}
+
+// Setup starts the root span of the command if tracing is enabled. The span continues the trace
+// given by the TRACEPARENT and TRACESTATE environment variables, if any.
+func Setup(command, version string) error {
+	name := exporterName()
+	if name == "none" {
+		return nil
+	}
+	ctx := context.Background()
+	exporter, err := newExporter(ctx, name)
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "aurl"),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	parent := propagator.Extract(ctx, propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	})
+	rootCtx, rootSpan = otel.Tracer(instrumentationName).Start(parent, "aurl "+command,
+		trace.WithAttributes(attribute.String("aurl.command", command)))
+	return nil
+}
+
+// Shutdown ends the root span and flushes the spans to the exporter. status is the exit status of aurl.
+func Shutdown(status int) {
+	if provider == nil {
+		return
+	}
+	if status != 0 {
+		rootSpan.SetStatus(codes.Error, fmt.Sprintf("exit status %d", status))
+	}
+	rootSpan.End()
+	provider.Shutdown(context.Background())
+	provider = nil
+	if closer != nil {
+		closer.Close()
+	}
+}
+
+// Enabled reports whether spans are exported
+func Enabled() bool {
+	return provider != nil
+}
+
+// Context returns the context of the root span
+func Context() context.Context {
+	return rootCtx
+}
+
+// Start starts a span, a no-op one if tracing is disabled
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient starts a span for an outgoing request
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// End records err, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject adds the traceparent header of the span in ctx to the headers
+func Inject(ctx context.Context, header http.Header) {
+	if !Enabled() {
+		return
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// EndResponse records the response status of an outgoing request on the span and ends it
+func EndResponse(span trace.Span, status int, err error) {
+	if status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 400 && err == nil {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+	End(span, err)
+}
@@ -1,6 +1,11 @@
 package util
 
-import "github.com/byteness/aws-vault/v7/prompt"
+import (
+	"os"
+
+	"github.com/byteness/aws-vault/v7/prompt"
+	"golang.org/x/term"
+)
 
 // promptWithDefault prompts the user with a message and returns the input or default value if empty
 func TerminalPromptWithDefault(message, defaultValue string) (string, error) {
@@ -21,3 +26,13 @@ func TerminalPrompt(message string) (string, error) {
 func TerminalSecretPrompt(message string) (string, error) {
 	return prompt.TerminalSecretPrompt(message)
 }
+
+// IsTerminal reports whether stdin is a terminal the user can be prompted on
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// IsStdoutTerminal reports whether stdout is a terminal, where output is pretty printed by default
+func IsStdoutTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
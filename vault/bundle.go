@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const bundleVersion = 1
+
+// scrypt parameters of the credentials key. Bundles with other parameters are refused, so an
+// imported bundle can't make aurl spend any amount of memory or time before checking the passphrase.
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	saltLength = 16
+)
+
+// Bundle is a portable set of profile definitions for aurl export and import.
+// Credentials are either left out or encrypted with a passphrase.
+type Bundle struct {
+	Version     int                   `json:"version"`
+	Profiles    []BundleProfile       `json:"profiles"`
+	Credentials *EncryptedCredentials `json:"credentials,omitempty"`
+}
+
+// BundleProfile is a profile section in config file format
+type BundleProfile struct {
+	Name   string `json:"name"`
+	Config string `json:"config"`
+}
+
+// EncryptedCredentials holds the credentials of the bundled profiles, keyed by profile name,
+// encrypted with ChaCha20-Poly1305 using a key derived from a passphrase with scrypt
+type EncryptedCredentials struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ErrWrongPassphrase is returned when the credentials of a bundle can't be decrypted
+var ErrWrongPassphrase = errors.New("Wrong passphrase or corrupted bundle")
+
+// NewBundle returns an empty bundle
+func NewBundle() *Bundle {
+	return &Bundle{Version: bundleVersion, Profiles: []BundleProfile{}}
+}
+
+// EncryptCredentials stores the credentials in the bundle, encrypted with the passphrase
+func (b *Bundle) EncryptCredentials(credentials map[string]Credentials, passphrase string) error {
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	enc := &EncryptedCredentials{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	enc.Salt = make([]byte, saltLength)
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return err
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return err
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, plaintext, nil)
+	b.Credentials = enc
+	return nil
+}
+
+// DecryptCredentials returns the credentials of the bundle keyed by profile name
+func (b *Bundle) DecryptCredentials(passphrase string) (map[string]Credentials, error) {
+	credentials := map[string]Credentials{}
+	if b.Credentials == nil {
+		return credentials, nil
+	}
+	aead, err := b.Credentials.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Credentials.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("Invalid bundle: nonce of %d bytes, want %d", len(b.Credentials.Nonce), aead.NonceSize())
+	}
+	plaintext, err := aead.Open(nil, b.Credentials.Nonce, b.Credentials.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return nil, fmt.Errorf("Invalid credentials in bundle: %w", err)
+	}
+	return credentials, nil
+}
+
+func (e *EncryptedCredentials) aead(passphrase string) (interface {
+	NonceSize() int
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}, error) {
+	if e.KDF != "scrypt" {
+		return nil, fmt.Errorf("Unsupported key derivation %q", e.KDF)
+	}
+	if e.N != scryptN || e.R != scryptR || e.P != scryptP {
+		return nil, fmt.Errorf("Unsupported scrypt parameters N=%d r=%d p=%d", e.N, e.R, e.P)
+	}
+	if len(e.Salt) != saltLength {
+		return nil, fmt.Errorf("Invalid bundle: salt of %d bytes, want %d", len(e.Salt), saltLength)
+	}
+	key, err := scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// ParseBundle reads a bundle written by aurl export
+func ParseBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("Invalid bundle: %w", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("Unsupported bundle version %d", b.Version)
+	}
+	return &b, nil
+}
@@ -0,0 +1,70 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func encryptedBundle(t *testing.T) []byte {
+	t.Helper()
+	b := NewBundle()
+	b.Profiles = append(b.Profiles, BundleProfile{Name: "prod", Config: "grant_type = client_credentials\n"})
+	credentials := map[string]Credentials{"prod": {ClientId: "client", ClientSecret: "secret"}}
+	if err := b.EncryptCredentials(credentials, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBundleCredentials(t *testing.T) {
+	b, err := ParseBundle(encryptedBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.DecryptCredentials("correct horse")
+	if err != nil {
+		t.Fatalf("DecryptCredentials: %v", err)
+	}
+	if want := map[string]Credentials{"prod": {ClientId: "client", ClientSecret: "secret"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecryptCredentials() = %v, want %v", got, want)
+	}
+	if _, err := b.DecryptCredentials("wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("DecryptCredentials(wrong) = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestTamperedBundle(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(e *EncryptedCredentials)
+	}{
+		{"huge N", func(e *EncryptedCredentials) { e.N = 1 << 40 }},
+		{"small N", func(e *EncryptedCredentials) { e.N = 2 }},
+		{"huge r", func(e *EncryptedCredentials) { e.R = 1 << 20 }},
+		{"huge p", func(e *EncryptedCredentials) { e.P = 1 << 20 }},
+		{"other KDF", func(e *EncryptedCredentials) { e.KDF = "pbkdf2" }},
+		{"short salt", func(e *EncryptedCredentials) { e.Salt = e.Salt[:4] }},
+		{"no salt", func(e *EncryptedCredentials) { e.Salt = nil }},
+		{"short nonce", func(e *EncryptedCredentials) { e.Nonce = e.Nonce[:8] }},
+		{"long nonce", func(e *EncryptedCredentials) { e.Nonce = append(e.Nonce, 0) }},
+		{"flipped ciphertext", func(e *EncryptedCredentials) { e.Ciphertext[0] ^= 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseBundle(encryptedBundle(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(b.Credentials)
+			if credentials, err := b.DecryptCredentials("correct horse"); err == nil {
+				t.Errorf("DecryptCredentials() = %v, want an error", credentials)
+			}
+		})
+	}
+}
@@ -3,15 +3,21 @@ package vault
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
 	ini "gopkg.in/ini.v1"
 )
 
 const (
 	defaultSectionName = "default"
+	// profilePrefix is the optional section name prefix, as in "[profile name]" used by aws-vault
+	profilePrefix = "profile "
 )
 
 func init() {
@@ -25,13 +31,55 @@ type Config struct {
 	TokenEndpoint         string
 	RedirectURI           string
 	Scope                 string
+	Audience              string
+	Resources             []string
+	ExtraTokenParams      map[string]string
+	ExtraAuthParams       map[string]string
 	ContentType           string
 	UserAgent             string
+	BaseURL               string
+	// Headers ("Name: value") and Query ("name=value") are added to every request
+	Headers []string
+	Query   []string
+	// AllowedHosts are glob patterns of the hosts the access token may be sent to, see HostPatterns
+	AllowedHosts []string
+	// AllowTokenDomain extends the default allowed hosts to the domain of the token endpoint
+	AllowTokenDomain bool
+}
+
+// Scopes returns the requested scope values, accepting both comma and space separators
+func (c *Config) Scopes() []string {
+	return ParseScopes(c.Scope)
+}
+
+// TokenCacheKey returns the key under which tokens obtained with this config are cached
+func (c *Config) TokenCacheKey() TokenCacheKey {
+	return TokenCacheKey{
+		Profile:     c.Name,
+		Scopes:      c.Scopes(),
+		Audience:    c.Audience,
+		Resources:   c.Resources,
+		TokenParams: c.ExtraTokenParams,
+		AuthParams:  c.ExtraAuthParams,
+	}
+}
+
+// ResolveURL returns the target URL, resolving paths against the base_url of the profile.
+// The path is appended to the path of base_url, e.g. "/users" to "https://api.example.com/v1".
+func (c *Config) ResolveURL(target string) (string, error) {
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" && u.Host != "" {
+		return target, nil
+	}
+	if c.BaseURL == "" {
+		return "", fmt.Errorf("URL %q is relative and profile '%s' has no base_url", target, c.Name)
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(target, "/"), nil
 }
 
 type ConfigFile struct {
 	Path    string
 	iniFile *ini.File
+	lines   *keyLines
 }
 
 func configPath() (string, error) {
@@ -43,7 +91,7 @@ func configPath() (string, error) {
 		}
 		file = filepath.Join(home, "/.aurl/config")
 	} else {
-		log.Printf("Using AURL_CONFIG_FILE value: %s", file)
+		slog.Debug("using AURL_CONFIG_FILE value", "path", file)
 	}
 	return file, nil
 }
@@ -61,7 +109,7 @@ func LoadConfig() (*ConfigFile, error) {
 			return nil, parseErr
 		}
 	} else {
-		log.Printf("Config file %s doesn't exist so lets create it", path)
+		slog.Debug("config file doesn't exist so lets create it", "path", path)
 		err := createConfigFilesIfMissing(path)
 		if err != nil {
 			return nil, err
@@ -80,25 +128,26 @@ func createConfigFilesIfMissing(path string) error {
 		if err != nil {
 			return err
 		}
-		log.Printf("Config directory %s created", dir)
+		slog.Debug("config directory created", "path", dir)
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		newFile, err := os.Create(path)
 		if err != nil {
-			log.Printf("Config file %s not created", path)
+			slog.Debug("config file not created", "path", path)
 			return err
 		}
 		newFile.Close()
-		log.Printf("Config file %s created", path)
+		slog.Debug("config file created", "path", path)
 	}
 	return nil
 }
 
 func (c *ConfigFile) parseFile() error {
-	log.Printf("Parsing config file %s", c.Path)
+	slog.Debug("parsing config file", "path", c.Path)
 
 	f, err := ini.LoadSources(ini.LoadOptions{
 		AllowNestedValues:   true,
+		AllowShadows:        true,
 		InsensitiveSections: false,
 		InsensitiveKeys:     true,
 	}, c.Path)
@@ -106,24 +155,175 @@ func (c *ConfigFile) parseFile() error {
 		return fmt.Errorf("Error parsing config file %s: %w", c.Path, err)
 	}
 	c.iniFile = f
+
+	if c.lines, err = scanKeyLines(c.Path); err != nil {
+		return fmt.Errorf("Error reading config file %s: %w", c.Path, err)
+	}
+	for _, problem := range c.UnknownKeys() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+	}
 	return nil
 }
 
+// KeyProblem is an issue with a key of the config file
+type KeyProblem struct {
+	Path    string
+	Line    int
+	Profile string
+	Key     string
+	Message string
+}
+
+func (p KeyProblem) String() string {
+	location := p.Path
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.Path, p.Line)
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: profile %q: %s", location, p.Profile, p.Message)
+	}
+	return fmt.Sprintf("%s: profile %q: %s: %s", location, p.Profile, p.Key, p.Message)
+}
+
+// IsKnownKey reports whether key is a valid profile key
+func IsKnownKey(key string) bool {
+	for _, field := range ProfileFields() {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// UnknownKeys returns the keys of the config file which aren't valid profile keys
+func (c *ConfigFile) UnknownKeys() []KeyProblem {
+	problems := []KeyProblem{}
+	if c.iniFile == nil || c.lines == nil {
+		return problems
+	}
+	for _, sectionName := range c.iniFile.SectionStrings() {
+		for _, key := range c.lines.order[sectionName] {
+			if IsKnownKey(key) && sectionName != ini.DefaultSection {
+				continue
+			}
+			problem := KeyProblem{
+				Path:    c.Path,
+				Line:    c.lines.keys[sectionName][key],
+				Profile: profileName(sectionName),
+				Key:     key,
+				Message: "unknown key",
+			}
+			if sectionName == ini.DefaultSection {
+				problem.Message = "key outside of any profile section"
+			}
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// Line returns the line number of the key in the profile's section, or of the
+// section header if key is empty. Zero if unknown.
+func (c *ConfigFile) Line(profile, key string) int {
+	if c.lines == nil {
+		return 0
+	}
+	sectionName := c.sectionName(profile)
+	if key == "" {
+		return c.lines.sections[sectionName]
+	}
+	return c.lines.keys[sectionName][strings.ToLower(key)]
+}
+
+// profileName returns the profile name of an ini section name
+func profileName(sectionName string) string {
+	return strings.TrimSpace(strings.TrimPrefix(sectionName, profilePrefix))
+}
+
+// sectionName returns the name of the ini section holding the profile, which is either the plain
+// profile name or the name with the "profile " prefix. When the file has both, the first one wins.
+func (c *ConfigFile) sectionName(profile string) string {
+	if c.iniFile != nil {
+		for _, section := range c.iniFile.SectionStrings() {
+			if section != ini.DefaultSection && profileName(section) == profile {
+				return section
+			}
+		}
+	}
+	return profile
+}
+
 // ProfileSection is a profile section of the config file
 type ProfileSection struct {
-	Name                    string `ini:"-"`
-	GrantType               string `ini:"grant_type"`
-	AuthServerAuthEndpoint  string `ini:"auth_server_auth_endpoint"`
-	AuthServerTokenEndpoint string `ini:"auth_server_token_endpoint"`
-	Redirect                string `ini:"redirect"`
-	Scope                   string `ini:"scopes"`
-	ContentType             string `ini:"content_type"`
-	UserAgent               string `ini:"user_agent"`
+	Name                    string   `ini:"-"`
+	GrantType               string   `ini:"grant_type"`
+	AuthServerAuthEndpoint  string   `ini:"auth_server_auth_endpoint"`
+	AuthServerTokenEndpoint string   `ini:"auth_server_token_endpoint"`
+	Redirect                string   `ini:"redirect"`
+	Extends                 string   `ini:"extends,omitempty"`
+	SourceProfile           string   `ini:"source_profile,omitempty"` // alias of extends, as in aws-vault
+	Scope                   string   `ini:"scopes"`
+	Audience                string   `ini:"audience,omitempty"`
+	Resources               []string `ini:"resource,omitempty,allowshadow"`
+	BaseURL                 string   `ini:"base_url,omitempty"`
+	Headers                 []string `ini:"header,omitempty,allowshadow"`
+	Query                   []string `ini:"query,omitempty,allowshadow"`
+	AllowedHosts            string   `ini:"allowed_hosts,omitempty"`
+	AllowTokenDomain        bool     `ini:"allow_token_domain,omitempty"`
+	ContentType             string   `ini:"content_type"`
+	UserAgent               string   `ini:"user_agent"`
+	AllowHTTPLocalhost      bool     `ini:"allow_http_localhost,omitempty"`
+
+	// ExtraTokenParams and ExtraAuthParams are written as nested values, e.g.
+	//   extra_token_params =
+	//     prompt = consent
+	ExtraTokenParams map[string]string `ini:"-"`
+	ExtraAuthParams  map[string]string `ini:"-"`
 }
 
+const (
+	extraTokenParamsKey = "extra_token_params"
+	extraAuthParamsKey  = "extra_auth_params"
+)
+
 func (s ProfileSection) IsEmpty() bool {
-	s.Name = ""
-	return s == ProfileSection{}
+	return reflect.DeepEqual(s, ProfileSection{Name: s.Name})
+}
+
+// nestedParams parses the "name = value" nested values of the key
+func nestedParams(section *ini.Section, key string) map[string]string {
+	if !section.HasKey(key) {
+		return nil
+	}
+	params := map[string]string{}
+	for _, nested := range section.Key(key).NestedValues() {
+		name, value, _ := strings.Cut(nested, "=")
+		params[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+// setNestedParams writes the params as nested values of the key, sorted by name
+func setNestedParams(section *ini.Section, key string, params map[string]string) error {
+	section.DeleteKey(key)
+	if len(params) == 0 {
+		return nil
+	}
+	k, err := section.NewKey(key, "")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := k.AddNestedValue(fmt.Sprintf("%s = %s", name, params[name])); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ProfileSections returns all the profile sections in the config
@@ -133,20 +333,27 @@ func (c *ConfigFile) ProfileSections() []ProfileSection {
 	if c.iniFile == nil {
 		return result
 	}
+	seen := map[string]bool{}
 	for _, section := range c.iniFile.SectionStrings() {
-		if section == defaultSectionName {
-			profile, _ := c.ProfileSection(section)
+		if section == ini.DefaultSection {
+			// keys before the first section header, which aurl doesn't use
+			continue
+		}
+		name := profileName(section)
+		if seen[name] {
+			slog.Debug("ignoring duplicate ini file section", "profile", name, "section", section)
+			continue
+		}
+		seen[name] = true
 
-			// ignore the default profile if it's empty
-			if section == defaultSectionName && profile.IsEmpty() {
-				continue
-			}
+		profile, _ := c.ProfileSection(name)
 
-			result = append(result, profile)
-		} else {
-			log.Printf("Unrecognised ini file section: %s", section)
+		// ignore the default profile if it's empty
+		if name == defaultSectionName && profile.IsEmpty() {
 			continue
 		}
+
+		result = append(result, profile)
 	}
 
 	return result
@@ -161,23 +368,26 @@ func (c *ConfigFile) ProfileSection(name string) (ProfileSection, bool) {
 	if c.iniFile == nil {
 		return profile, false
 	}
-	// default profile name has a slightly different section format
-	sectionName := name
-	if name == defaultSectionName {
-		sectionName = defaultSectionName
-	}
-	section, err := c.iniFile.GetSection(sectionName)
+	// profile sections may also be written as [profile name]
+	section, err := c.iniFile.GetSection(c.sectionName(name))
 	if err != nil {
 		return profile, false
 	}
 	if err = section.MapTo(&profile); err != nil {
 		panic(err)
 	}
+	profile.ExtraTokenParams = nestedParams(section, extraTokenParamsKey)
+	profile.ExtraAuthParams = nestedParams(section, extraAuthParamsKey)
 	return profile, true
 }
 
 func (c *ConfigFile) Save() error {
-	return c.iniFile.SaveTo(c.Path)
+	if err := c.iniFile.SaveTo(c.Path); err != nil {
+		return err
+	}
+	var err error
+	c.lines, err = scanKeyLines(c.Path)
+	return err
 }
 
 // Add the profile to the configuration file
@@ -185,14 +395,23 @@ func (c *ConfigFile) Add(profile ProfileSection) error {
 	if c.iniFile == nil {
 		return errors.New("No iniFile to add to")
 	}
-	// default profile name has a slightly different section format
-	section, err := c.iniFile.NewSection(profile.Name)
+	// replace the existing section in place, which may be written as [profile name]
+	section, err := c.iniFile.NewSection(c.sectionName(profile.Name))
 	if err != nil {
 		return fmt.Errorf("Error creating section %q: %v", profile.Name, err)
 	}
+	for _, key := range section.KeyStrings() {
+		section.DeleteKey(key)
+	}
 	if err = section.ReflectFrom(&profile); err != nil {
 		return fmt.Errorf("Error mapping profile to ini file: %v", err)
 	}
+	if err = setNestedParams(section, extraTokenParamsKey, profile.ExtraTokenParams); err != nil {
+		return fmt.Errorf("Error mapping profile to ini file: %v", err)
+	}
+	if err = setNestedParams(section, extraAuthParamsKey, profile.ExtraAuthParams); err != nil {
+		return fmt.Errorf("Error mapping profile to ini file: %v", err)
+	}
 	return c.Save()
 }
 
@@ -216,11 +435,141 @@ func NewConfigLoader(file *ConfigFile, activeProfile string) *ConfigLoader {
 	}
 }
 
-func (cl *ConfigLoader) GetProfileConfig(profileName string) (*Config, error) {
+// Parent returns the name of the profile this profile extends, if any
+func (s ProfileSection) Parent() string {
+	if s.Extends != "" {
+		return s.Extends
+	}
+	return s.SourceProfile
+}
+
+// nestedKeyNames maps the fields written as nested values to their key names
+var nestedKeyNames = map[string]string{
+	"ExtraTokenParams": extraTokenParamsKey,
+	"ExtraAuthParams":  extraAuthParamsKey,
+}
+
+// ProfileField is a settable key of a profile section
+type ProfileField struct {
+	Key   string
+	index int
+}
+
+// ProfileFields returns the keys of a profile section in file order
+func ProfileFields() []ProfileField {
+	fields := []ProfileField{}
+	t := reflect.TypeOf(ProfileSection{})
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("ini"), ",")
+		if key == "-" {
+			if key = nestedKeyNames[t.Field(i).Name]; key == "" {
+				continue
+			}
+		}
+		fields = append(fields, ProfileField{Key: key, index: i})
+	}
+	return fields
+}
+
+// Value returns the value of the field in the profile section
+func (f ProfileField) Value(s ProfileSection) interface{} {
+	return reflect.ValueOf(s).Field(f.index).Interface()
+}
+
+// IsSet reports whether the field has a non-empty value in the profile section
+func (f ProfileField) IsSet(s ProfileSection) bool {
+	v := reflect.ValueOf(s).Field(f.index)
+	if v.Kind() == reflect.Map || v.Kind() == reflect.Slice {
+		return v.Len() > 0
+	}
+	return !v.IsZero()
+}
 
-	profileSection, ok := cl.File.ProfileSection(profileName)
-	if !ok {
-		return nil, fmt.Errorf("Profile '%s' not found in config file", profileName)
+func (f ProfileField) copy(dst *ProfileSection, src ProfileSection) {
+	reflect.ValueOf(dst).Elem().Field(f.index).Set(reflect.ValueOf(src).Field(f.index))
+}
+
+// isInheritanceKey reports whether the key controls inheritance rather than being inherited
+func isInheritanceKey(key string) bool {
+	return key == "extends" || key == "source_profile"
+}
+
+// profileChain returns the profile followed by the profiles it inherits from, ending with [default]
+func (cl *ConfigLoader) profileChain(profileName string) ([]ProfileSection, error) {
+	chain := []ProfileSection{}
+	visited := map[string]bool{}
+	path := []string{}
+
+	for name := profileName; name != ""; {
+		path = append(path, name)
+		if visited[name] {
+			return nil, fmt.Errorf("Profile inheritance cycle: %s", strings.Join(path, " -> "))
+		}
+		visited[name] = true
+
+		section, ok := cl.File.ProfileSection(name)
+		if !ok {
+			if len(chain) == 0 {
+				return nil, fmt.Errorf("Profile '%s' not found in config file", name)
+			}
+			return nil, fmt.Errorf("Profile '%s' extends unknown profile '%s'", path[len(path)-2], name)
+		}
+		chain = append(chain, section)
+		name = section.Parent()
+	}
+	if !visited[defaultSectionName] {
+		if section, ok := cl.File.ProfileSection(defaultSectionName); ok {
+			chain = append(chain, section)
+		}
+	}
+	return chain, nil
+}
+
+// ResolveProfile merges the profile with the profiles it extends and the [default] section,
+// which acts as an implicit base. The first non-empty value along the chain wins. The returned
+// map records the section each effective key came from.
+func (cl *ConfigLoader) ResolveProfile(profileName string) (ProfileSection, map[string]string, error) {
+	chain, err := cl.profileChain(profileName)
+	if err != nil {
+		return ProfileSection{}, nil, err
+	}
+
+	sources := map[string]string{}
+	resolved := ProfileSection{Name: profileName}
+	for _, field := range ProfileFields() {
+		if isInheritanceKey(field.Key) {
+			if field.IsSet(chain[0]) {
+				field.copy(&resolved, chain[0])
+				sources[field.Key] = chain[0].Name
+			}
+			continue
+		}
+		for _, section := range chain {
+			if field.IsSet(section) {
+				field.copy(&resolved, section)
+				sources[field.Key] = section.Name
+				break
+			}
+		}
+	}
+	return resolved, sources, nil
+}
+
+// GetProfileConfig returns the effective config of the profile, the active profile if profileName is empty
+func (cl *ConfigLoader) GetProfileConfig(profileName string) (*Config, error) {
+	if profileName == "" {
+		profileName = cl.ActiveProfile
+	}
+	if problems := cl.ValidateProfile(profileName, false); len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	profileSection, _, err := cl.EffectiveProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	grantType := profileSection.GrantType
+	if grantType == "" {
+		grantType = DefaultGrantType
 	}
 	contentType := profileSection.ContentType
 	if contentType == "" {
@@ -233,13 +582,22 @@ func (cl *ConfigLoader) GetProfileConfig(profileName string) (*Config, error) {
 
 	config := Config{
 		Name:                  profileName,
-		GrantType:             profileSection.GrantType,
+		GrantType:             grantType,
 		AuthorizationEndpoint: profileSection.AuthServerAuthEndpoint,
 		TokenEndpoint:         profileSection.AuthServerTokenEndpoint,
 		RedirectURI:           profileSection.Redirect,
 		Scope:                 profileSection.Scope,
+		Audience:              profileSection.Audience,
+		Resources:             profileSection.Resources,
+		ExtraTokenParams:      profileSection.ExtraTokenParams,
+		ExtraAuthParams:       profileSection.ExtraAuthParams,
 		ContentType:           contentType,
 		UserAgent:             userAgent,
+		BaseURL:               profileSection.BaseURL,
+		Headers:               append([]string{}, profileSection.Headers...),
+		Query:                 append([]string{}, profileSection.Query...),
+		AllowedHosts:          ParseAllowedHosts(profileSection.AllowedHosts),
+		AllowTokenDomain:      profileSection.AllowTokenDomain,
 	}
 
 	return &config, nil
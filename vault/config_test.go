@@ -0,0 +1,314 @@
+package vault
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// loadConfigString writes the config to a temporary file and loads it with LoadConfig
+func loadConfigString(t *testing.T, content string) *ConfigFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AURL_CONFIG_FILE", path)
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return config
+}
+
+func TestProfileName(t *testing.T) {
+	tests := []struct {
+		section string
+		want    string
+	}{
+		{"default", "default"},
+		{"profile default", "default"},
+		{"prod", "prod"},
+		{"profile prod", "prod"},
+		{"profile  spaced ", "spaced"},
+		{"profiles", "profiles"},
+	}
+	for _, tt := range tests {
+		if got := profileName(tt.section); got != tt.want {
+			t.Errorf("profileName(%q) = %q, want %q", tt.section, got, tt.want)
+		}
+	}
+}
+
+func TestProfileSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// want maps the profiles, in order, to their token endpoint
+		want [][2]string
+	}{
+		{
+			name:    "empty",
+			content: "",
+			want:    [][2]string{},
+		},
+		{
+			name: "mixed plain and prefixed sections",
+			content: `[default]
+auth_server_token_endpoint = https://default.example.com/token
+
+[profile prod]
+auth_server_token_endpoint = https://prod.example.com/token
+
+[dev]
+auth_server_token_endpoint = https://dev.example.com/token
+`,
+			want: [][2]string{
+				{"default", "https://default.example.com/token"},
+				{"prod", "https://prod.example.com/token"},
+				{"dev", "https://dev.example.com/token"},
+			},
+		},
+		{
+			name: "duplicate prefixed section first",
+			content: `[profile prod]
+auth_server_token_endpoint = https://first.example.com/token
+
+[prod]
+auth_server_token_endpoint = https://second.example.com/token
+`,
+			want: [][2]string{{"prod", "https://first.example.com/token"}},
+		},
+		{
+			name: "duplicate plain section first",
+			content: `[prod]
+auth_server_token_endpoint = https://first.example.com/token
+
+[profile prod]
+auth_server_token_endpoint = https://second.example.com/token
+`,
+			want: [][2]string{{"prod", "https://first.example.com/token"}},
+		},
+		{
+			name: "profile default",
+			content: `[profile default]
+auth_server_token_endpoint = https://default.example.com/token
+`,
+			want: [][2]string{{"default", "https://default.example.com/token"}},
+		},
+		{
+			name: "empty default is skipped",
+			content: `[default]
+
+[prod]
+auth_server_token_endpoint = https://prod.example.com/token
+`,
+			want: [][2]string{{"prod", "https://prod.example.com/token"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadConfigString(t, tt.content)
+			got := [][2]string{}
+			for _, profile := range config.ProfileSections() {
+				got = append(got, [2]string{profile.Name, profile.AuthServerTokenEndpoint})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProfileSections() = %v, want %v", got, tt.want)
+			}
+			for _, want := range tt.want {
+				profile, ok := config.ProfileSection(want[0])
+				if !ok || profile.AuthServerTokenEndpoint != want[1] {
+					t.Errorf("ProfileSection(%q) = %q, %v, want %q", want[0], profile.AuthServerTokenEndpoint, ok, want[1])
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []KeyProblem
+	}{
+		{
+			name: "known keys only",
+			content: `[prod]
+grant_type = client_credentials
+auth_server_token_endpoint = https://prod.example.com/token
+extra_token_params =
+  prompt = consent
+`,
+			want: []KeyProblem{},
+		},
+		{
+			name: "unknown keys in mixed sections",
+			content: `[default]
+grant_type = client_credentials
+
+[profile prod]
+; a comment
+grant_typo = password
+Scopes = read
+GRANT_TYPO = again
+
+[dev]
+base_url = https://dev.example.com
+colour = blue
+`,
+			want: []KeyProblem{
+				{Line: 6, Profile: "prod", Key: "grant_typo", Message: "unknown key"},
+				{Line: 12, Profile: "dev", Key: "colour", Message: "unknown key"},
+			},
+		},
+		{
+			name: "key outside of any section",
+			content: `grant_type = password
+[prod]
+scopes = read
+`,
+			want: []KeyProblem{
+				{Line: 1, Profile: "DEFAULT", Key: "grant_type", Message: "key outside of any profile section"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadConfigString(t, tt.content)
+			for i := range tt.want {
+				tt.want[i].Path = config.Path
+			}
+			if got := config.UnknownKeys(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnknownKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFixture(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "mixed.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := loadConfigString(t, string(content))
+
+	if got, want := config.ProfileNames(), []string{"default", "prod", "staging", "dev"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProfileNames() = %v, want %v", got, want)
+	}
+	if got := config.Line("staging", "scopes"); got != 14 {
+		t.Errorf("Line(staging, scopes) = %d, want 14", got)
+	}
+	if got := config.Line("dev", ""); got != 17 {
+		t.Errorf("Line(dev) = %d, want 17", got)
+	}
+
+	staging, err := NewConfigLoader(config, "staging").GetProfileConfig("staging")
+	if err != nil {
+		t.Fatalf("GetProfileConfig(staging): %v", err)
+	}
+	if staging.TokenEndpoint != "https://auth.example.com/token" {
+		t.Errorf("staging inherits the token endpoint %q", staging.TokenEndpoint)
+	}
+	if staging.Scope != "read" {
+		t.Errorf("staging overrides the scopes with %q", staging.Scope)
+	}
+	if want := map[string]string{"prompt": "consent"}; !reflect.DeepEqual(staging.ExtraAuthParams, want) {
+		t.Errorf("staging inherits the extra auth params %v, want %v", staging.ExtraAuthParams, want)
+	}
+}
+
+func TestUnknownKeysAreWarningsOnly(t *testing.T) {
+	config := loadConfigString(t, `[default]
+colour = blue
+
+[prod]
+grant_type = client_credentials
+auth_server_token_endpoint = https://auth.example.com/token
+scopes = read
+setting_of_a_newer_aurl = 1
+`)
+	loader := NewConfigLoader(config, "prod")
+	if _, err := loader.GetProfileConfig("prod"); err != nil {
+		t.Errorf("GetProfileConfig fails on unknown keys: %v", err)
+	}
+	if problems := loader.ValidateProfile("prod", false); len(problems) != 0 {
+		t.Errorf("ValidateProfile() = %v, want no problems", problems)
+	}
+	var keys []string
+	for _, problem := range loader.UnknownKeys("prod") {
+		keys = append(keys, problem.Key)
+	}
+	if want := []string{"colour", "setting_of_a_newer_aurl"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("UnknownKeys(prod) = %v, want %v", keys, want)
+	}
+}
+
+func TestReservedExtraParams(t *testing.T) {
+	config := loadConfigString(t, `[prod]
+grant_type = client_credentials
+auth_server_token_endpoint = https://auth.example.com/token
+extra_token_params =
+  grant_type = password
+  prompt = consent
+extra_auth_params =
+  state = fixed
+`)
+	var messages []string
+	for _, problem := range NewConfigLoader(config, "prod").ValidateProfile("prod", false) {
+		messages = append(messages, problem.Key+": "+problem.Message)
+	}
+	want := []string{
+		`extra_auth_params: "state" is set by aurl and can't be replaced`,
+		`extra_token_params: "grant_type" is set by aurl and can't be replaced`,
+	}
+	sort.Strings(messages)
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("ValidateProfile() = %q, want %q", messages, want)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	config := loadConfigString(t, `[prod]
+grant_type = client_credentials
+auth_server_token_endpoint = https://auth.example.com/token
+scopes = read
+audience = api
+`)
+	t.Setenv("AURL_SCOPES", "read write")
+	t.Setenv("AURL_AUDIENCE", "other")
+	t.Setenv("AURL_PROFILE_PROD_AUDIENCE", "prod-api")
+	profile, sources, err := NewConfigLoader(config, "prod").EffectiveProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Scope != "read write" {
+		t.Errorf("scopes = %q, want the ones of AURL_SCOPES", profile.Scope)
+	}
+	if profile.Audience != "prod-api" {
+		t.Errorf("audience = %q, want the one of AURL_PROFILE_PROD_AUDIENCE", profile.Audience)
+	}
+	if sources["scopes"] != "env AURL_SCOPES" || sources["audience"] != "env AURL_PROFILE_PROD_AUDIENCE" {
+		t.Errorf("sources = %v", sources)
+	}
+}
+
+func TestDuplicateSectionLines(t *testing.T) {
+	config := loadConfigString(t, `[prod]
+grant_type = client_credentials
+
+[dev]
+scopes = read
+
+[prod]
+scopes = write
+`)
+	if got := config.Line("prod", ""); got != 1 {
+		t.Errorf("Line(prod) = %d, want the first section at 1", got)
+	}
+	if got := config.Line("prod", "grant_type"); got != 2 {
+		t.Errorf("Line(prod, grant_type) = %d, want 2", got)
+	}
+}
@@ -0,0 +1,288 @@
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// tokenIndependentKeys are the profile keys which don't affect the tokens issued for the profile
+var tokenIndependentKeys = map[string]bool{
+	"content_type":         true,
+	"user_agent":           true,
+	"base_url":             true,
+	"header":               true,
+	"query":                true,
+	"allowed_hosts":        true,
+	"allow_token_domain":   true,
+	"allow_http_localhost": true,
+}
+
+// AffectsTokens reports whether changing the key invalidates tokens cached for the profile
+func AffectsTokens(key string) bool {
+	key, _, _ = strings.Cut(key, ".")
+	return !tokenIndependentKeys[key]
+}
+
+// TokenSettingsChanged reports whether any key affecting tokens differs between the two profile sections
+func TokenSettingsChanged(before, after ProfileSection) bool {
+	for _, field := range ProfileFields() {
+		if AffectsTokens(field.Key) && field.IsSet(before) != field.IsSet(after) {
+			return true
+		}
+		if AffectsTokens(field.Key) && field.IsSet(before) && !reflect.DeepEqual(field.Value(before), field.Value(after)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMultiValuedKey reports whether the key may be repeated
+func isMultiValuedKey(key string) bool {
+	t := reflect.TypeOf(ProfileSection{})
+	for i := 0; i < t.NumField(); i++ {
+		name, options, _ := strings.Cut(t.Field(i).Tag.Get("ini"), ",")
+		if name == key {
+			return strings.Contains(options, "allowshadow")
+		}
+	}
+	return false
+}
+
+// isNestedKey reports whether the key holds nested "name = value" pairs
+func isNestedKey(key string) bool {
+	for _, nestedKey := range nestedKeyNames {
+		if nestedKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConfigFile) existingSection(profile string) (*ini.Section, error) {
+	if c.iniFile == nil {
+		return nil, fmt.Errorf("Profile '%s' not found in config file", profile)
+	}
+	section, err := c.iniFile.GetSection(c.sectionName(profile))
+	if err != nil {
+		return nil, fmt.Errorf("Profile '%s' not found in config file", profile)
+	}
+	return section, nil
+}
+
+// SetKey sets the key of the profile to the values. Only multi-valued keys accept more than one value.
+// Nested values are addressed as "extra_token_params.name".
+func (c *ConfigFile) SetKey(profile, key string, values []string) error {
+	section, err := c.existingSection(profile)
+	if err != nil {
+		return err
+	}
+	key = strings.ToLower(key)
+	if name, param, isParam := strings.Cut(key, "."); isParam {
+		if !isNestedKey(name) {
+			return fmt.Errorf("Unknown key %q", key)
+		}
+		params := nestedParams(section, name)
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[param] = values[len(values)-1]
+		return setNestedParams(section, name, params)
+	}
+	if !IsKnownKey(key) || isNestedKey(key) {
+		return fmt.Errorf("Unknown key %q", key)
+	}
+	if len(values) > 1 && !isMultiValuedKey(key) {
+		return fmt.Errorf("Key %q takes a single value", key)
+	}
+
+	section.DeleteKey(key)
+	k, err := section.NewKey(key, values[0])
+	if err != nil {
+		return err
+	}
+	for _, value := range values[1:] {
+		if err := k.AddShadow(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnsetKey removes the key from the profile. Returns false if the key wasn't set.
+func (c *ConfigFile) UnsetKey(profile, key string) (bool, error) {
+	section, err := c.existingSection(profile)
+	if err != nil {
+		return false, err
+	}
+	key = strings.ToLower(key)
+	if name, param, isParam := strings.Cut(key, "."); isParam {
+		params := nestedParams(section, name)
+		if _, ok := params[param]; !ok {
+			return false, nil
+		}
+		delete(params, param)
+		return true, setNestedParams(section, name, params)
+	}
+	if !section.HasKey(key) {
+		return false, nil
+	}
+	section.DeleteKey(key)
+	return true, nil
+}
+
+// SectionText returns the profile section as it would be written to the config file
+func (c *ConfigFile) SectionText(profile string) (string, error) {
+	section, err := c.existingSection(profile)
+	if err != nil {
+		return "", err
+	}
+	f := ini.Empty(ini.LoadOptions{AllowNestedValues: true, AllowShadows: true})
+	copySection(f.Section(section.Name()), section)
+	var b strings.Builder
+	if _, err := f.WriteTo(&b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ReplaceSection replaces the keys of the profile with those of the single section in text,
+// as produced by SectionText and edited by the user. Problems are returned without changing anything.
+func (c *ConfigFile) ReplaceSection(profile, text string) ([]KeyProblem, error) {
+	section, err := c.existingSection(profile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ini.LoadSources(ini.LoadOptions{
+		AllowNestedValues:   true,
+		AllowShadows:        true,
+		InsensitiveSections: false,
+		InsensitiveKeys:     true,
+	}, []byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing edited profile: %w", err)
+	}
+
+	problems := []KeyProblem{}
+	var edited *ini.Section
+	for _, s := range f.Sections() {
+		switch {
+		case s.Name() == ini.DefaultSection && len(s.Keys()) == 0:
+		case profileName(s.Name()) == profile && edited == nil:
+			edited = s
+		default:
+			problems = append(problems, KeyProblem{Profile: profile, Message: fmt.Sprintf("unexpected section [%s], only [%s] can be edited", s.Name(), section.Name())})
+		}
+	}
+	if edited == nil {
+		problems = append(problems, KeyProblem{Profile: profile, Message: fmt.Sprintf("section [%s] is missing", section.Name())})
+		return problems, nil
+	}
+	for _, key := range edited.KeyStrings() {
+		if !IsKnownKey(key) {
+			problems = append(problems, KeyProblem{Profile: profile, Key: key, Message: "unknown key"})
+		} else if len(edited.Key(key).ValueWithShadows()) > 1 && !isMultiValuedKey(key) {
+			problems = append(problems, KeyProblem{Profile: profile, Key: key, Message: "takes a single value"})
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	for _, key := range section.KeyStrings() {
+		section.DeleteKey(key)
+	}
+	copySection(section, edited)
+	return nil, nil
+}
+
+// copySection copies the keys of src including shadows and nested values into dst
+func copySection(dst, src *ini.Section) {
+	for _, key := range src.Keys() {
+		values := key.ValueWithShadows()
+		if len(values) == 0 {
+			// keys without a value, such as those holding nested values
+			values = []string{""}
+		}
+		k, _ := dst.NewKey(key.Name(), values[0])
+		for _, value := range values[1:] {
+			k.AddShadow(value)
+		}
+		for _, nested := range key.NestedValues() {
+			k.AddNestedValue(nested)
+		}
+	}
+}
+
+// Dependents returns the profile and every profile inheriting settings from it
+func (c *ConfigFile) Dependents(profile string) []string {
+	dependents := []string{}
+	loader := NewConfigLoader(c, profile)
+	for _, name := range c.ProfileNames() {
+		chain, err := loader.profileChain(name)
+		if err != nil {
+			continue
+		}
+		for _, section := range chain {
+			if section.Name == profile {
+				dependents = append(dependents, name)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// CopyProfile copies the section of the profile src to a new profile dst
+func (c *ConfigFile) CopyProfile(src, dst string) error {
+	section, err := c.existingSection(src)
+	if err != nil {
+		return err
+	}
+	if _, exists := c.ProfileSection(dst); exists {
+		return fmt.Errorf("Profile %q already exists in config at %s", dst, c.Path)
+	}
+	newSection, err := c.iniFile.NewSection(dst)
+	if err != nil {
+		return fmt.Errorf("Error creating section %q: %v", dst, err)
+	}
+	copySection(newSection, section)
+	return nil
+}
+
+// RenameProfile renames the profile, updating the profiles extending it
+func (c *ConfigFile) RenameProfile(oldName, newName string) error {
+	if oldName == defaultSectionName {
+		return fmt.Errorf("The %s profile can't be renamed", defaultSectionName)
+	}
+	if err := c.CopyProfile(oldName, newName); err != nil {
+		return err
+	}
+	for _, profile := range c.ProfileSections() {
+		section, _ := c.existingSection(profile.Name)
+		for _, key := range []string{"extends", "source_profile"} {
+			if section.HasKey(key) && section.Key(key).String() == oldName {
+				section.Key(key).SetValue(newName)
+			}
+		}
+	}
+	c.iniFile.DeleteSection(c.sectionName(oldName))
+	return nil
+}
+
+// AddSectionText adds the single section in text, as produced by SectionText, as the profile
+func (c *ConfigFile) AddSectionText(profile, text string) ([]KeyProblem, error) {
+	if _, exists := c.ProfileSection(profile); !exists {
+		if _, err := c.iniFile.NewSection(profile); err != nil {
+			return nil, fmt.Errorf("Error creating section %q: %v", profile, err)
+		}
+		problems, err := c.ReplaceSection(profile, text)
+		if len(problems) > 0 || err != nil {
+			c.iniFile.DeleteSection(profile)
+		}
+		return problems, err
+	}
+	return c.ReplaceSection(profile, text)
+}
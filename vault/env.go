@@ -0,0 +1,167 @@
+package vault
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProfileEnvVar names the profile used when none is given on the command line
+const ProfileEnvVar = "AURL_PROFILE"
+
+// DefaultProfile returns the profile named by AURL_PROFILE, if any
+func DefaultProfile() string {
+	return os.Getenv(ProfileEnvVar)
+}
+
+// reservedEnvVars are the variables of aurl itself, never read as the override of a key
+var reservedEnvVars = map[string]bool{
+	ProfileEnvVar:            true,
+	"AURL_CONFIG_FILE":       true,
+	"AURL_AGENT_SOCK":        true,
+	"AURL_OTEL_TRACES_FILE":  true,
+	"AURL_BUNDLE_PASSPHRASE": true,
+}
+
+// envVarNames returns the environment variables overriding the key of the profile, in increasing
+// precedence: AURL_<KEY> for every profile, AURL_PROFILE_<NAME>_<KEY> for one
+func envVarNames(profileName, key string) []string {
+	upper := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(s))
+	}
+	names := []string{}
+	if name := "AURL_" + upper(key); !reservedEnvVars[name] {
+		names = append(names, name)
+	}
+	return append(names, "AURL_PROFILE_"+upper(profileName)+"_"+upper(key))
+}
+
+// setFromString sets the field from an environment variable value. Lists are comma or space
+// separated, except headers which are one per line and query parameters which are joined by "&".
+// Nested params are given in query form, e.g. "prompt=consent&tenant=t1".
+func (f ProfileField) setFromString(dst *ProfileSection, value string) error {
+	v := reflect.ValueOf(dst).Elem().Field(f.index)
+	switch v.Kind() {
+	case reflect.Slice:
+		// header values contain spaces and commas, query values are joined as in a query string
+		separators := map[string]string{"header": "\n", "query": "&"}
+		sep, ok := separators[f.Key]
+		if !ok {
+			sep = ", "
+		}
+		v.Set(reflect.ValueOf(strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(sep, r) })))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Map:
+		query, err := url.ParseQuery(value)
+		if err != nil {
+			return err
+		}
+		params := map[string]string{}
+		for name := range query {
+			params[name] = query.Get(name)
+		}
+		v.Set(reflect.ValueOf(params))
+	default:
+		v.SetString(value)
+	}
+	return nil
+}
+
+var interpolationPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolate replaces ${NAME} with the value of the environment variable NAME
+func interpolate(value string) (string, error) {
+	var err error
+	result := interpolationPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return result, err
+}
+
+// interpolate expands the environment variable references in the field's value
+func (f ProfileField) interpolate(s *ProfileSection) error {
+	v := reflect.ValueOf(s).Elem().Field(f.index)
+	switch value := v.Interface().(type) {
+	case string:
+		expanded, err := interpolate(value)
+		if err != nil {
+			return err
+		}
+		v.SetString(expanded)
+	case []string:
+		expanded := make([]string, len(value))
+		for i, item := range value {
+			var err error
+			if expanded[i], err = interpolate(item); err != nil {
+				return err
+			}
+		}
+		v.Set(reflect.ValueOf(expanded))
+	case map[string]string:
+		expanded := make(map[string]string, len(value))
+		for name, item := range value {
+			var err error
+			if expanded[name], err = interpolate(item); err != nil {
+				return err
+			}
+		}
+		v.Set(reflect.ValueOf(expanded))
+	}
+	return nil
+}
+
+// EffectiveProfile resolves the profile like ResolveProfile, then expands ${NAME} references
+// to environment variables and applies the AURL_<KEY> and AURL_PROFILE_<NAME>_<KEY> overrides.
+// Keys set from the environment are recorded in the sources as "env <VAR>".
+func (cl *ConfigLoader) EffectiveProfile(profileName string) (ProfileSection, map[string]string, error) {
+	profile, sources, err := cl.ResolveProfile(profileName)
+	if err != nil {
+		return profile, nil, err
+	}
+	for _, field := range ProfileFields() {
+		if isInheritanceKey(field.Key) {
+			continue
+		}
+		if err := field.interpolate(&profile); err != nil {
+			source := sources[field.Key]
+			problem := KeyProblem{
+				Path:    cl.File.Path,
+				Line:    cl.File.Line(source, field.Key),
+				Profile: source,
+				Key:     field.Key,
+				Message: err.Error(),
+			}
+			return profile, nil, problem
+		}
+		for _, name := range envVarNames(profileName, field.Key) {
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+			if err := field.setFromString(&profile, value); err != nil {
+				return profile, nil, fmt.Errorf("Invalid value of %s: %w", name, err)
+			}
+			sources[field.Key] = "env " + name
+		}
+	}
+	return profile, sources, nil
+}
@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ParseAllowedHosts splits an allowed_hosts value into its comma or space separated patterns
+func ParseAllowedHosts(value string) []string {
+	return strings.FieldsFunc(strings.ToLower(value), func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// HostPatterns returns the glob patterns of the hosts the access token may be sent to: the
+// allowed_hosts of the profile, by default the host of base_url, or else the host of the token
+// endpoint (the authorization endpoint for the implicit grant). With allow_token_domain, the
+// domain of that endpoint and its subdomains are allowed instead of its host alone.
+func (c *Config) HostPatterns() []string {
+	if len(c.AllowedHosts) > 0 {
+		return c.AllowedHosts
+	}
+	for _, endpoint := range []string{c.BaseURL, c.TokenEndpoint, c.AuthorizationEndpoint} {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		// a shared identity provider domain such as auth0.com would let other tenants receive the token
+		if endpoint == c.BaseURL || !c.AllowTokenDomain || net.ParseIP(host) != nil {
+			return []string{host}
+		}
+		domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+		if err != nil {
+			return []string{host}
+		}
+		return []string{domain, "*." + domain}
+	}
+	return []string{}
+}
+
+// IsAllowedHost reports whether the URL's host matches one of the host patterns of the profile.
+// Patterns with a port, such as example.com:8443 or [::1]:8080, only match that port.
+func (c *Config) IsAllowedHost(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range c.HostPatterns() {
+		patternHost, port, err := net.SplitHostPort(pattern)
+		if err == nil {
+			if port != urlPort(u) {
+				continue
+			}
+		} else {
+			// a bare IPv6 address, bracketed or not
+			patternHost = strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]")
+		}
+		if ok, _ := path.Match(patternHost, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// urlPort returns the port of the URL, the default port of its scheme if it has none
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return map[string]string{"http": "80", "https": "443"}[u.Scheme]
+}
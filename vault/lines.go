@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// keyLines records the line numbers of the sections and keys of the config file,
+// which the ini parser doesn't keep. Keys are lower cased as the parser is case insensitive.
+type keyLines struct {
+	sections map[string]int
+	keys     map[string]map[string]int
+	// order lists the keys of each section as they appear in the file
+	order map[string][]string
+}
+
+func scanKeyLines(path string) (*keyLines, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := &keyLines{
+		sections: map[string]int{},
+		keys:     map[string]map[string]int{},
+		order:    map[string][]string{},
+	}
+	section := ini.DefaultSection
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			// the first of duplicate sections is the one reported, as it is the one profiles resolve to
+			if _, seen := lines.sections[section]; !seen {
+				lines.sections[section] = lineNo
+			}
+			if lines.keys[section] == nil {
+				lines.keys[section] = map[string]int{}
+			}
+		case raw[0] == ' ' || raw[0] == '\t':
+			// nested value or continuation of the previous key
+		default:
+			key := line
+			if i := strings.IndexAny(line, "=:"); i >= 0 {
+				key = strings.TrimSpace(line[:i])
+			}
+			key = strings.ToLower(key)
+			if lines.keys[section] == nil {
+				lines.keys[section] = map[string]int{}
+			}
+			if _, seen := lines.keys[section][key]; !seen {
+				lines.keys[section][key] = lineNo
+				lines.order[section] = append(lines.order[section], key)
+			}
+		}
+	}
+	return lines, scanner.Err()
+}
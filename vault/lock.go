@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockDirName      = "locks"
+	lockPollInterval = 100 * time.Millisecond
+	lockTimeout      = 5 * time.Minute
+)
+
+var errLocked = errors.New("lock is held by another process")
+
+// TokenLock is a per profile file lock serialising token refreshes across aurl processes
+type TokenLock struct {
+	Path string
+	file *os.File
+}
+
+// NewTokenLock returns the (unlocked) token lock of the profile, kept in the locks
+// directory next to the config file. The profile name is escaped so that names such as
+// team/prod or ../x stay in that directory, and : or * don't break the file name on Windows.
+func NewTokenLock(configFile *ConfigFile, profileName string) *TokenLock {
+	return &TokenLock{
+		Path: filepath.Join(filepath.Dir(configFile.Path), lockDirName, url.QueryEscape(profileName)+".lock"),
+	}
+}
+
+// Lock blocks until the lock is acquired or the timeout elapses
+func (l *TokenLock) Lock() error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if err != errLocked {
+			f.Close()
+			return fmt.Errorf("Error locking %s: %w", l.Path, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return fmt.Errorf("Timed out waiting for lock %s", l.Path)
+		}
+		slog.Debug("waiting for token lock held by another process", "path", l.Path)
+		time.Sleep(lockPollInterval)
+	}
+	slog.Debug("token lock acquired", "path", l.Path)
+	l.file = f
+	return nil
+}
+
+// Unlock releases the lock. The lock file itself is kept for the next process.
+func (l *TokenLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	defer func() {
+		l.file.Close()
+		l.file = nil
+	}()
+	slog.Debug("token lock released", "path", l.Path)
+	return unlockFile(l.file)
+}
@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTokenLock(t *testing.T) {
+	dir := t.TempDir()
+	config := &ConfigFile{Path: filepath.Join(dir, "config")}
+	locks := filepath.Join(dir, lockDirName)
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"prod", "prod.lock"},
+		{"team/prod", "team%2Fprod.lock"},
+		{"..", "...lock"},
+		{"../../etc/x", "..%2F..%2Fetc%2Fx.lock"},
+		{`c:\x*`, "c%3A%5Cx%2A.lock"},
+	}
+	for _, tt := range tests {
+		lock := NewTokenLock(config, tt.profile)
+		if want := filepath.Join(locks, tt.want); lock.Path != want {
+			t.Errorf("NewTokenLock(%q).Path = %s, want %s", tt.profile, lock.Path, want)
+		}
+		if filepath.Dir(lock.Path) != locks {
+			t.Errorf("NewTokenLock(%q) is outside of %s: %s", tt.profile, locks, lock.Path)
+		}
+	}
+
+	lock := NewTokenLock(config, "team/prod")
+	if err := lock.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/byteness/keyring"
+	"github.com/classmethod/aurl/redact"
+)
+
+// SecretKeyringPrefix is prepended to the name of secrets referenced from the config file
+const SecretKeyringPrefix = "secret:"
+
+// secretReferencePattern matches ${keyring:NAME} references in header and query values
+var secretReferencePattern = regexp.MustCompile(`\$\{keyring:([^}]+)\}`)
+
+// SecretKeyring stores the secret values referenced as ${keyring:NAME} in header and query keys
+type SecretKeyring struct {
+	Keyring keyring.Keyring
+}
+
+func (skr *SecretKeyring) Get(name string) (string, error) {
+	item, err := skr.Keyring.Get(SecretKeyringPrefix + name)
+	if err != nil {
+		return "", err
+	}
+	return string(item.Data), nil
+}
+
+func (skr *SecretKeyring) Set(name, value string) error {
+	return skr.Keyring.Set(keyring.Item{
+		Key:   SecretKeyringPrefix + name,
+		Label: fmt.Sprintf("aurl secret (%s)", name),
+		Data:  []byte(value),
+
+		// specific Keychain settings
+		KeychainNotTrustApplication: true,
+	})
+}
+
+func (skr *SecretKeyring) Remove(name string) error {
+	return skr.Keyring.Remove(SecretKeyringPrefix + name)
+}
+
+// Names returns the names of the stored secrets
+func (skr *SecretKeyring) Names() ([]string, error) {
+	keys, err := skr.Keyring.Keys()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, key := range keys {
+		if name, ok := strings.CutPrefix(key, SecretKeyringPrefix); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// expand replaces the ${keyring:NAME} references in value with the stored secrets
+func (skr *SecretKeyring) expand(value string) (string, error) {
+	var err error
+	result := secretReferencePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := secretReferencePattern.FindStringSubmatch(ref)[1]
+		secret, getErr := skr.Get(name)
+		if getErr != nil && err == nil {
+			err = fmt.Errorf("Secret %q not found in keyring, set it with: aurl secret set %s: %w", name, name, getErr)
+		}
+		redact.AddSecret(secret)
+		return secret
+	})
+	return result, err
+}
+
+// SecretReferences returns the names of the secrets referenced in the values
+func SecretReferences(values []string) []string {
+	names := []string{}
+	for _, value := range values {
+		for _, match := range secretReferencePattern.FindAllStringSubmatch(value, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// ResolveSecrets replaces the ${keyring:NAME} references in the headers and query parameters of the config
+func (c *Config) ResolveSecrets(skr *SecretKeyring) error {
+	for _, values := range [][]string{c.Headers, c.Query} {
+		for i, value := range values {
+			expanded, err := skr.expand(value)
+			if err != nil {
+				return err
+			}
+			values[i] = expanded
+		}
+	}
+	return nil
+}
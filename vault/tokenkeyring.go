@@ -1,9 +1,13 @@
 package vault
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,33 +17,157 @@ import (
 const TokenKeyringSuffix = "-TokenInfo"
 
 type Tokens struct {
-	AccessToken  string `json:"access_token"`
-	IdToken      string `json:"id_token"`
-	TokenType    string `json:"token_type"`
-	RefreshToken string `json:"refresh_token"`
-	Scope        string `json:"scope"`
-	ExpiresIn    *int64 `json:"expires_in"`
-	Expires      *int64 `json:"expires"` // broken Facebook spelling of expires_in
+	AccessToken      string `json:"access_token"`
+	IdToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	Scope            string `json:"scope"`
+	ExpiresIn        *int64 `json:"expires_in"`
+	Expires          *int64 `json:"expires"`            // broken Facebook spelling of expires_in
+	RefreshExpiresIn *int64 `json:"refresh_expires_in"` // Keycloak extension
 }
 
 type TokenInfo struct {
 	Tokens *Tokens
 	// RequestTime is the time when the token was requested
 	RequestTimestamp int64
+	// RefreshExpiresTimestamp is the time the refresh token expires, zero if unknown
+	RefreshExpiresTimestamp int64 `json:",omitempty"`
+	// CacheKey is the key the token was stored under, set by TokenKeyring.Set
+	CacheKey *TokenCacheKey `json:",omitempty"`
 }
 
 func (t *TokenInfo) IsExpired() bool {
+	// Consider token expired 60 seconds before actual expiration to avoid edge cases
+	return t.ExpiresWithin(60 * time.Second)
+}
 
-	if t.Tokens.ExpiresIn == nil {
+// ExpiresWithin reports whether the access token expires within the given duration
+func (t *TokenInfo) ExpiresWithin(d time.Duration) bool {
+	if t.Tokens == nil || t.Tokens.ExpiresIn == nil {
 		return false
 	}
-	// Consider token expired 60 seconds before actual expiration to avoid edge cases
-	expirationTime := t.RequestTimestamp + *t.Tokens.ExpiresIn - 60
+	expirationTime := t.RequestTimestamp + *t.Tokens.ExpiresIn - int64(d.Seconds())
 	currentTime := time.Now().Unix()
-	log.Printf("TokenInfo.IsExpired: CurrentTime=%d, ExpirationTime=%d", currentTime, expirationTime)
+	slog.Debug("TokenInfo.ExpiresWithin", "current_time", currentTime, "expiration_time", expirationTime)
 	return currentTime >= expirationTime
 }
 
+// ParseScopes splits a scope string on commas and whitespace, dropping duplicates
+func ParseScopes(scope string) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, s := range strings.FieldsFunc(scope, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// MissingScopes returns the requested scopes which are not part of the granted scope string.
+// An empty granted scope means the server granted exactly what was requested (RFC 6749 section 5.1).
+func MissingScopes(requested []string, granted string) []string {
+	missing := []string{}
+	if granted == "" {
+		return missing
+	}
+	grantedScopes := map[string]bool{}
+	for _, s := range ParseScopes(granted) {
+		grantedScopes[s] = true
+	}
+	for _, s := range requested {
+		if !grantedScopes[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// TokenCacheKey identifies a cached token by profile, requested scope set and audience
+type TokenCacheKey struct {
+	Profile   string
+	Scopes    []string `json:",omitempty"`
+	Audience  string   `json:",omitempty"`
+	Resources []string `json:",omitempty"`
+	// TokenParams and AuthParams are the extra_token_params and extra_auth_params, which may change the token
+	TokenParams map[string]string `json:",omitempty"`
+	AuthParams  map[string]string `json:",omitempty"`
+}
+
+// IsTokenKeyName reports whether the keyring item name holds a cached token
+func IsTokenKeyName(keyName string) bool {
+	return strings.HasSuffix(keyName, TokenKeyringSuffix) || strings.Contains(keyName, TokenKeyringSuffix+"-")
+}
+
+// String returns a human readable description of the key
+func (k TokenCacheKey) String() string {
+	parts := []string{k.Profile}
+	if len(k.Scopes) > 0 {
+		parts = append(parts, "scope="+strings.Join(k.Scopes, " "))
+	}
+	if k.Audience != "" {
+		parts = append(parts, "audience="+k.Audience)
+	}
+	if len(k.Resources) > 0 {
+		parts = append(parts, "resource="+strings.Join(k.Resources, " "))
+	}
+	if len(k.TokenParams) > 0 || len(k.AuthParams) > 0 {
+		parts = append(parts, "params="+k.params())
+	}
+	return strings.Join(parts, " ")
+}
+
+// params returns the extra params as a sorted query string, token params first
+func (k TokenCacheKey) params() string {
+	params := []string{}
+	for _, extra := range []struct {
+		prefix string
+		params map[string]string
+	}{{"token.", k.TokenParams}, {"auth.", k.AuthParams}} {
+		names := make([]string, 0, len(extra.params))
+		for name := range extra.params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			params = append(params, url.QueryEscape(extra.prefix+name)+"="+url.QueryEscape(extra.params[name]))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// keyName returns the keyring item name. Tokens without scope or audience keep the
+// plain profile name, others get a digest of the normalised scope set and audience appended.
+func (k TokenCacheKey) keyName() string {
+	keyName := k.Profile + TokenKeyringSuffix
+	if len(k.Scopes) == 0 && k.Audience == "" && len(k.Resources) == 0 && len(k.TokenParams) == 0 && len(k.AuthParams) == 0 {
+		return keyName
+	}
+	scopes := append([]string{}, k.Scopes...)
+	sort.Strings(scopes)
+	resources := append([]string{}, k.Resources...)
+	sort.Strings(resources)
+	data := fmt.Sprintf("scope=%s\naudience=%s\nresource=%s", strings.Join(scopes, " "), k.Audience, strings.Join(resources, " "))
+	// appended only when set, so the keys of tokens cached without extra params don't change
+	if params := k.params(); params != "" {
+		data += "\nparams=" + params
+	}
+	digest := sha256.Sum256([]byte(data))
+	return keyName + "-" + hex.EncodeToString(digest[:6])
+}
+
+// IsRefreshExpired reports whether the refresh token is known to have expired
+func (t *TokenInfo) IsRefreshExpired() bool {
+	if t.RefreshExpiresTimestamp == 0 {
+		return false
+	}
+	currentTime := time.Now().Unix()
+	slog.Debug("TokenInfo.IsRefreshExpired", "current_time", currentTime, "expiration_time", t.RefreshExpiresTimestamp)
+	return currentTime >= t.RefreshExpiresTimestamp
+}
+
 type TokenKeyring struct {
 	Keyring keyring.Keyring
 }
@@ -59,22 +187,26 @@ func (tkr *TokenKeyring) lookupKeyName(key string) (string, error) {
 	return key, ErrNotFound
 }
 
-func (tkr *TokenKeyring) Get(profileName string) (tokenInfo *TokenInfo, err error) {
-	keyName := profileName + TokenKeyringSuffix
+func (tkr *TokenKeyring) Get(key TokenCacheKey) (tokenInfo *TokenInfo, err error) {
+	return tkr.getItem(key.keyName())
+}
+
+func (tkr *TokenKeyring) getItem(keyName string) (tokenInfo *TokenInfo, err error) {
 	item, err := tkr.Keyring.Get(keyName)
 	if err != nil {
-		log.Printf("TokenKeyring.Get: Failed to get token from keyring: %v", err)
+		slog.Debug("TokenKeyring.Get: failed to get token from keyring", "key", keyName, "error", err)
 		return tokenInfo, err
 	}
 	if err = json.Unmarshal(item.Data, &tokenInfo); err != nil {
-		log.Printf("TokenKeyring: Ignoring invalid data: %s", err.Error())
+		slog.Debug("TokenKeyring: ignoring invalid data", "key", keyName, "error", err)
 		return tokenInfo, ErrNotFound
 	}
 	return tokenInfo, err
 }
 
-func (tkr *TokenKeyring) Set(profileName string, tokenInfo *TokenInfo) error {
-	keyName := profileName + TokenKeyringSuffix
+func (tkr *TokenKeyring) Set(key TokenCacheKey, tokenInfo *TokenInfo) error {
+	keyName := key.keyName()
+	tokenInfo.CacheKey = &key
 	valJSON, err := json.Marshal(tokenInfo)
 	if err != nil {
 		return err
@@ -83,16 +215,63 @@ func (tkr *TokenKeyring) Set(profileName string, tokenInfo *TokenInfo) error {
 	return tkr.Keyring.Set(keyring.Item{
 		Key:         keyName,
 		Data:        valJSON,
-		Label:       fmt.Sprintf("aurl token for %s (expires in %d seconds)", profileName, tokenInfo.Tokens.ExpiresIn),
+		Label:       fmt.Sprintf("aurl token for %s", key),
 		Description: "aurl token",
 	})
 }
 
-func (tkr *TokenKeyring) Remove(profileName string) error {
-	keyName, err := tkr.lookupKeyName(profileName + TokenKeyringSuffix)
+func (tkr *TokenKeyring) Remove(key TokenCacheKey) error {
+	keyName, err := tkr.lookupKeyName(key.keyName())
 	if err != nil && err != ErrNotFound {
 		return err
 	}
 
 	return tkr.Keyring.Remove(keyName)
 }
+
+// RemoveAll removes every cached token of the profile regardless of scope and audience
+func (tkr *TokenKeyring) RemoveAll(profileName string) error {
+	allKeys, err := tkr.Keyring.Keys()
+	if err != nil {
+		return err
+	}
+	prefix := profileName + TokenKeyringSuffix
+	for _, keyName := range allKeys {
+		if keyName != prefix && !strings.HasPrefix(keyName, prefix+"-") {
+			continue
+		}
+		if err := tkr.Keyring.Remove(keyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move renames the cached tokens of a profile
+func (tkr *TokenKeyring) Move(oldProfile, newProfile string) error {
+	allKeys, err := tkr.Keyring.Keys()
+	if err != nil {
+		return err
+	}
+	prefix := oldProfile + TokenKeyringSuffix
+	for _, keyName := range allKeys {
+		if keyName != prefix && !strings.HasPrefix(keyName, prefix+"-") {
+			continue
+		}
+		tokenInfo, err := tkr.getItem(keyName)
+		if err != nil || tokenInfo.CacheKey == nil {
+			// tokens without a key can't be renamed, they will be requested again
+			slog.Debug("TokenKeyring.Move: dropping token", "key", keyName)
+		} else {
+			key := *tokenInfo.CacheKey
+			key.Profile = newProfile
+			if err := tkr.Set(key, tokenInfo); err != nil {
+				return err
+			}
+		}
+		if err := tkr.Keyring.Remove(keyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/byteness/keyring"
+)
+
+func TestTokenCacheKeyName(t *testing.T) {
+	base := TokenCacheKey{Profile: "prod", Scopes: []string{"read", "write"}}
+	tests := []struct {
+		name string
+		key  TokenCacheKey
+		same bool
+	}{
+		{"scope order", TokenCacheKey{Profile: "prod", Scopes: []string{"write", "read"}}, true},
+		{"other scopes", TokenCacheKey{Profile: "prod", Scopes: []string{"read"}}, false},
+		{"audience", TokenCacheKey{Profile: "prod", Scopes: []string{"read", "write"}, Audience: "api"}, false},
+		{"extra token params", TokenCacheKey{Profile: "prod", Scopes: []string{"read", "write"}, TokenParams: map[string]string{"tenant": "a"}}, false},
+		{"extra auth params", TokenCacheKey{Profile: "prod", Scopes: []string{"read", "write"}, AuthParams: map[string]string{"tenant": "a"}}, false},
+		{"empty params", TokenCacheKey{Profile: "prod", Scopes: []string{"read", "write"}, TokenParams: map[string]string{}}, true},
+	}
+	for _, tt := range tests {
+		if same := tt.key.keyName() == base.keyName(); same != tt.same {
+			t.Errorf("%s: keyName() %s, same as %s: %v, want %v", tt.name, tt.key.keyName(), base.keyName(), same, tt.same)
+		}
+	}
+
+	tenantA := TokenCacheKey{Profile: "prod", TokenParams: map[string]string{"tenant": "a"}}
+	tenantB := TokenCacheKey{Profile: "prod", TokenParams: map[string]string{"tenant": "b"}}
+	authA := TokenCacheKey{Profile: "prod", AuthParams: map[string]string{"tenant": "a"}}
+	if tenantA.keyName() == tenantB.keyName() || tenantA.keyName() == authA.keyName() {
+		t.Errorf("extra params don't change the key: %s %s %s", tenantA.keyName(), tenantB.keyName(), authA.keyName())
+	}
+	// keys of tokens cached before extra params were part of the key are unchanged
+	if got, want := base.keyName(), "prod"+TokenKeyringSuffix+"-"; got[:len(want)] != want || (TokenCacheKey{Profile: "prod"}).keyName() != "prod"+TokenKeyringSuffix {
+		t.Errorf("keyName() = %s", got)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	kr := keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "prod" + TokenKeyringSuffix},
+		{Key: "prod" + TokenKeyringSuffix + "-0123abcd"},
+		{Key: "Prod" + TokenKeyringSuffix},
+		{Key: "PROD" + TokenKeyringSuffix + "-0123abcd"},
+		{Key: "prod-eu" + TokenKeyringSuffix},
+		{Key: "prod"},
+	})
+	if err := (&TokenKeyring{Keyring: kr}).RemoveAll("prod"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := kr.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	want := []string{"PROD" + TokenKeyringSuffix + "-0123abcd", "Prod" + TokenKeyringSuffix, "prod", "prod-eu" + TokenKeyringSuffix}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("RemoveAll(prod) leaves %v, want %v", keys, want)
+	}
+}
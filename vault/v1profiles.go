@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// V1Profile is a profile section of the aurl v1 ~/.aurl/profiles file, which kept
+// secrets in plain text next to the settings
+type V1Profile struct {
+	Name                    string `ini:"-"`
+	GrantType               string `ini:"grant_type"`
+	ClientId                string `ini:"client_id"`
+	ClientSecret            string `ini:"client_secret"`
+	AuthServerAuthEndpoint  string `ini:"auth_server_auth_endpoint"`
+	AuthServerTokenEndpoint string `ini:"auth_server_token_endpoint"`
+	Redirect                string `ini:"redirect"`
+	Scope                   string `ini:"scopes"`
+	Username                string `ini:"username"`
+	Password                string `ini:"password"`
+	DefaultContentType      string `ini:"default_content_type"`
+	DefaultUserAgent        string `ini:"default_user_agent"`
+}
+
+// V1ProfilesPath returns the location of the aurl v1 profiles file
+func V1ProfilesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "/.aurl/profiles"), nil
+}
+
+// LoadV1Profiles reads an aurl v1 profiles file, applying the v1 defaults
+func LoadV1Profiles(path string) ([]V1Profile, error) {
+	f, err := ini.LoadSources(ini.LoadOptions{
+		InsensitiveSections: false,
+		InsensitiveKeys:     true,
+	}, path)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing v1 profiles file %s: %w", path, err)
+	}
+
+	profiles := []V1Profile{}
+	for _, section := range f.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		// aurl v1 defaults
+		profile := V1Profile{
+			Name:         section.Name(),
+			GrantType:    "authorization_code",
+			ClientId:     "aurl",
+			ClientSecret: "aurl",
+			Scope:        "read write",
+		}
+		if err := section.MapTo(&profile); err != nil {
+			return nil, fmt.Errorf("Error parsing profile %q in %s: %w", section.Name(), path, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// ProfileSection returns the v2 config section of the profile
+func (p V1Profile) ProfileSection() ProfileSection {
+	return ProfileSection{
+		Name:                    p.Name,
+		GrantType:               p.GrantType,
+		AuthServerAuthEndpoint:  p.AuthServerAuthEndpoint,
+		AuthServerTokenEndpoint: p.AuthServerTokenEndpoint,
+		Redirect:                p.Redirect,
+		Scope:                   p.Scope,
+		ContentType:             p.DefaultContentType,
+		UserAgent:               p.DefaultUserAgent,
+	}
+}
+
+// Credentials returns the secrets of the profile which v2 keeps in the keyring
+func (p V1Profile) Credentials() Credentials {
+	return Credentials{
+		ClientId:     p.ClientId,
+		ClientSecret: p.ClientSecret,
+		Username:     p.Username,
+		Password:     p.Password,
+	}
+}
@@ -0,0 +1,289 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultGrantType is used by profiles without grant_type
+const DefaultGrantType = "authorization_code"
+
+// grantRequiredKeys lists the config keys each grant type needs
+var grantRequiredKeys = map[string][]string{
+	"authorization_code": {"auth_server_auth_endpoint", "auth_server_token_endpoint", "redirect"},
+	"implicit":           {"auth_server_auth_endpoint", "redirect"},
+	"password":           {"auth_server_token_endpoint"},
+	"client_credentials": {"auth_server_token_endpoint"},
+}
+
+// grantRequiredCredentials lists the keyring credentials each grant type needs
+var grantRequiredCredentials = map[string][]string{
+	"authorization_code": {"client_id"},
+	"implicit":           {"client_id"},
+	"password":           {"username", "password"},
+	"client_credentials": {"client_id", "client_secret"},
+}
+
+// outOfBandRedirects are the redirect URIs of the out-of-band flow, where the code is copied by the user
+var outOfBandRedirects = map[string]bool{
+	"urn:ietf:wg:oauth:2.0:oob":      true,
+	"urn:ietf:wg:oauth:2.0:oob:auto": true,
+}
+
+// reservedParams are the parameters set by aurl, or by dedicated keys, which extra params must not replace
+var reservedParams = map[string]map[string]bool{
+	extraTokenParamsKey: setOf("grant_type", "code", "code_verifier", "redirect_uri", "refresh_token", "username",
+		"password", "scope", "audience", "resource", "client_id", "client_secret"),
+	extraAuthParamsKey: setOf("response_type", "client_id", "redirect_uri", "scope", "state", "audience", "resource",
+		"code_challenge", "code_challenge_method"),
+}
+
+func setOf(values ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// ConfigError reports every problem found with a profile
+type ConfigError struct {
+	Problems []KeyProblem
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p KeyProblem) Error() string {
+	return p.String()
+}
+
+// UnknownKeys returns the unknown keys of the profile and of the profiles it inherits from. They are
+// only warnings when the config is loaded, so a typo or a key of a newer aurl doesn't break requests,
+// and errors for config validate.
+func (cl *ConfigLoader) UnknownKeys(profileName string) []KeyProblem {
+	problems := []KeyProblem{}
+	chain, err := cl.profileChain(profileName)
+	if err != nil {
+		// reported by ValidateProfile
+		return problems
+	}
+	inChain := map[string]bool{}
+	for _, section := range chain {
+		inChain[section.Name] = true
+	}
+	for _, problem := range cl.File.UnknownKeys() {
+		if inChain[problem.Profile] {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// ValidateProfile checks the effective settings of the profile: the keys required by its grant type
+// and the syntax of its URLs. Required keys aren't checked for base profiles, which only provide
+// settings to the profiles extending them.
+func (cl *ConfigLoader) ValidateProfile(profileName string, base bool) []KeyProblem {
+	problems := []KeyProblem{}
+	if _, err := cl.profileChain(profileName); err != nil {
+		return append(problems, KeyProblem{Path: cl.File.Path, Line: cl.File.Line(profileName, ""), Profile: profileName, Message: err.Error()})
+	}
+
+	profile, sources, err := cl.EffectiveProfile(profileName)
+	if err != nil {
+		var problem KeyProblem
+		if !errors.As(err, &problem) {
+			problem = KeyProblem{Path: cl.File.Path, Profile: profileName, Message: err.Error()}
+		}
+		return append(problems, problem)
+	}
+
+	// at locates the problem at the key, in the section or environment variable its value comes from
+	at := func(key, message string) KeyProblem {
+		problem := KeyProblem{Path: cl.File.Path, Profile: profileName, Key: key, Message: message}
+		switch source := sources[key]; {
+		case strings.HasPrefix(source, "env "):
+			problem.Path = "$" + strings.TrimPrefix(source, "env ")
+		case source != "":
+			problem.Line = cl.File.Line(source, key)
+			if source != profileName {
+				problem.Message += fmt.Sprintf(" (set in profile %q)", source)
+			}
+		default:
+			problem.Line = cl.File.Line(profileName, "")
+		}
+		return problem
+	}
+
+	grantType := profile.GrantType
+	if grantType == "" {
+		grantType = DefaultGrantType
+	}
+	required, ok := grantRequiredKeys[grantType]
+	if !ok {
+		problems = append(problems, at("grant_type", fmt.Sprintf("unknown grant type %q, expected one of %s", grantType, strings.Join(GrantTypes(), ", "))))
+	} else if !base {
+		for _, key := range required {
+			if _, set := sources[key]; !set {
+				problems = append(problems, at(key, fmt.Sprintf("required for grant type %s", grantType)))
+			}
+		}
+	}
+
+	for key, endpoint := range map[string]string{
+		"auth_server_auth_endpoint":  profile.AuthServerAuthEndpoint,
+		"auth_server_token_endpoint": profile.AuthServerTokenEndpoint,
+		"base_url":                   profile.BaseURL,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		if err := checkEndpoint(endpoint, profile.AllowHTTPLocalhost); err != nil {
+			problems = append(problems, at(key, err.Error()))
+		}
+	}
+	if profile.Redirect != "" {
+		if err := checkRedirect(profile.Redirect); err != nil {
+			problems = append(problems, at("redirect", err.Error()))
+		}
+	}
+	for _, resource := range profile.Resources {
+		if u, err := url.Parse(resource); err != nil || !u.IsAbs() || u.Fragment != "" {
+			problems = append(problems, at("resource", fmt.Sprintf("%q is not an absolute URI without fragment", resource)))
+		}
+	}
+
+	for _, header := range profile.Headers {
+		if name, _, ok := strings.Cut(header, ":"); !ok || strings.TrimSpace(name) == "" {
+			problems = append(problems, at("header", fmt.Sprintf("%q is not in the form Name: value", header)))
+		}
+	}
+	for _, param := range profile.Query {
+		if name, _, ok := strings.Cut(param, "="); !ok || name == "" {
+			problems = append(problems, at("query", fmt.Sprintf("%q is not in the form name=value", param)))
+		}
+	}
+
+	for key, params := range map[string]map[string]string{
+		extraTokenParamsKey: profile.ExtraTokenParams,
+		extraAuthParamsKey:  profile.ExtraAuthParams,
+	} {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if reservedParams[key][name] {
+				problems = append(problems, at(key, fmt.Sprintf("%q is set by aurl and can't be replaced", name)))
+			}
+		}
+	}
+
+	for _, pattern := range ParseAllowedHosts(profile.AllowedHosts) {
+		if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+			problems = append(problems, at("allowed_hosts", fmt.Sprintf("%q is not a host name pattern", pattern)))
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Path != problems[j].Path {
+			return problems[i].Path < problems[j].Path
+		}
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// GrantTypes returns the supported grant types
+func GrantTypes() []string {
+	grantTypes := []string{}
+	for grantType := range grantRequiredKeys {
+		grantTypes = append(grantTypes, grantType)
+	}
+	sort.Strings(grantTypes)
+	return grantTypes
+}
+
+// MissingCredentials returns the credentials required by the grant type which are empty
+func MissingCredentials(grantType string, creds *Credentials) []string {
+	if grantType == "" {
+		grantType = DefaultGrantType
+	}
+	values := map[string]string{
+		"client_id":     creds.ClientId,
+		"client_secret": creds.ClientSecret,
+		"username":      creds.Username,
+		"password":      creds.Password,
+	}
+	missing := []string{}
+	for _, name := range grantRequiredCredentials[grantType] {
+		if values[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// checkEndpoint requires an absolute https URL, or http to a loopback host if allowHTTPLocalhost is set
+func checkEndpoint(endpoint string, allowHTTPLocalhost bool) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", endpoint)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("%q must not have a fragment", endpoint)
+	}
+	if u.Scheme == "http" {
+		if !isLoopback(u.Hostname()) {
+			return fmt.Errorf("%q must use https", endpoint)
+		}
+		if !allowHTTPLocalhost {
+			return fmt.Errorf("%q uses http, set allow_http_localhost = true to allow it for localhost", endpoint)
+		}
+	}
+	return nil
+}
+
+// checkRedirect accepts absolute URIs without fragment, including the private-use schemes of
+// native apps, with http only for loopback hosts (RFC 8252), and the out-of-band URNs
+func checkRedirect(redirect string) error {
+	if outOfBandRedirects[redirect] {
+		return nil
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return fmt.Errorf("invalid URI: %v", err)
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("%q is not an absolute URI", redirect)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("%q must not have a fragment", redirect)
+	}
+	if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+		return fmt.Errorf("%q must use https unless redirecting to localhost", redirect)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}